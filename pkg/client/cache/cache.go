@@ -2,23 +2,113 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 )
 
+const (
+	// NoExpiration is returned by TTL and HashTTL for a key or a field without expiration
+	NoExpiration time.Duration = -1
+	// KeyNotExists is returned by HashTTL for a missing field
+	KeyNotExists time.Duration = -2
+)
+
+var (
+	// ErrNotFound is returned by reads of a missing key, hash or hash field, batch reads
+	// (MGet, HashMGet) return nil values for missing keys instead
+	ErrNotFound = errors.New("key not found in cache")
+	// ErrWrongType is returned by operations against a key holding the wrong kind of value
+	ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+	// ErrInvalidSetOptions is returned when mutually exclusive set options are combined
+	ErrInvalidSetOptions = errors.New("invalid set options")
+	// ErrTimeout is returned when the command didn't complete before the deadline
+	ErrTimeout = errors.New("cache command timed out")
+	// ErrCanceled is returned when the context of the command was canceled
+	ErrCanceled = errors.New("cache command canceled")
+	// ErrHashFieldTTLNotSupported is returned by HashExpire and HashTTL when the server doesn't support HPEXPIRE (redis < 7.4)
+	ErrHashFieldTTLNotSupported = errors.New("hash field expiration is not supported")
+)
+
+// SetMode is a condition of the write
+type SetMode int
+
+const (
+	// SetAlways writes the value unconditionally
+	SetAlways SetMode = iota
+	// SetIfNotExists writes the value only if the key doesn't exist (NX)
+	SetIfNotExists
+	// SetIfExists writes the value only if the key already exists (XX)
+	SetIfExists
+)
+
+// SetOptions are optional arguments of SetWithOptions
+type SetOptions struct {
+	// TTL sets key expiration with millisecond precision, zero means no expiration
+	TTL time.Duration
+	// Mode is a condition of the write
+	Mode SetMode
+	// KeepTTL retains the expiration of the existing key, can't be combined with TTL
+	KeepTTL bool
+	// Get returns the previous value of the key
+	Get bool
+}
+
+// Validate checks that options may be combined
+func (o SetOptions) Validate() error {
+	if o.TTL < 0 {
+		return fmt.Errorf("%w: negative ttl", ErrInvalidSetOptions)
+	}
+
+	if o.KeepTTL && o.TTL > 0 {
+		return fmt.Errorf("%w: ttl and keep ttl are mutually exclusive", ErrInvalidSetOptions)
+	}
+
+	return nil
+}
+
 // Cache interface for working with a cache
 type Cache interface {
 	Set(ctx context.Context, key string, value interface{}) error
+	SetWithOptions(ctx context.Context, key string, value interface{}, opts SetOptions) (written bool, previous interface{}, err error)
 	Get(ctx context.Context, key string) (interface{}, error)
+	MSet(ctx context.Context, values map[string]interface{}) error
+	MGet(ctx context.Context, keys ...string) ([]interface{}, error)
+	MDelete(ctx context.Context, keys ...string) error
 	HashSet(ctx context.Context, key string, values interface{}) error
 	HashGetAll(ctx context.Context, key string) ([]interface{}, error)
+	HashGet(ctx context.Context, key string, field string) (interface{}, error)
+	HashMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error)
+	HashDel(ctx context.Context, key string, fields ...string) (int64, error)
+	HashIncrBy(ctx context.Context, key string, field string, increment int64) (int64, error)
+	HashExists(ctx context.Context, key string, field string) (bool, error)
+	HashScanStruct(ctx context.Context, key string, dest interface{}) error
+	HashExpire(ctx context.Context, key string, expiration time.Duration, fields ...string) ([]bool, error)
+	HashTTL(ctx context.Context, key string, fields ...string) ([]time.Duration, error)
 	Expire(ctx context.Context, key string, expiration time.Duration) error
+	PExpire(ctx context.Context, key string, expiration time.Duration) (bool, error)
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	Persist(ctx context.Context, key string) (bool, error)
 	Delete(ctx context.Context, key string) error
 	Ping(ctx context.Context) error
 	Close() error
 }
 
+// PoolStats is a snapshot of connection pool statistics
+type PoolStats struct {
+	// ActiveCount is the number of connections in the pool including idle ones
+	ActiveCount int
+	// IdleCount is the number of idle connections in the pool
+	IdleCount int
+	// WaitCount is the total number of connections waited for
+	WaitCount int64
+	// WaitDuration is the total time blocked waiting for a new connection
+	WaitDuration time.Duration
+}
+
 // Client interface for working with a cache
 type Client interface {
 	Cache() Cache
+	Stats() PoolStats
 	Close() error
 }
@@ -0,0 +1,181 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// header bytes are invalid in UTF-8 so that they never start text values like JSON
+const (
+	headerRaw   byte = 0xf5
+	headerFlate byte = 0xf6
+	headerGzip  byte = 0xf7
+)
+
+// ErrValueTooLarge is returned when a value decompresses to more than the configured limit
+var ErrValueTooLarge = errors.New("decompressed value is too large")
+
+// Algorithm compresses values, values of all algorithms are read by their header regardless of the configured one
+type Algorithm interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	header() byte
+}
+
+type gzipAlgorithm struct {
+	writers sync.Pool
+}
+
+// Gzip returns gzip compression with the level of compress/gzip
+func Gzip(level int) (Algorithm, error) {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		return nil, err
+	}
+
+	a := &gzipAlgorithm{}
+	a.writers.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}
+
+	return a, nil
+}
+
+// Name returns name of the algorithm
+func (a *gzipAlgorithm) Name() string {
+	return "gzip"
+}
+
+// Compress compresses data with gzip
+func (a *gzipAlgorithm) Compress(data []byte) ([]byte, error) {
+	w := a.writers.Get().(*gzip.Writer)
+	defer a.writers.Put(w)
+
+	var buf bytes.Buffer
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func gunzip(data []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return readLimited(r, limit)
+}
+
+func (a *gzipAlgorithm) header() byte {
+	return headerGzip
+}
+
+type flateAlgorithm struct {
+	writers sync.Pool
+}
+
+// Flate returns raw deflate compression with the level of compress/flate, it skips
+// gzip framing and checksum and with flate.BestSpeed is the fastest option
+func Flate(level int) (Algorithm, error) {
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		return nil, err
+	}
+
+	a := &flateAlgorithm{}
+	a.writers.New = func() interface{} {
+		w, _ := flate.NewWriter(io.Discard, level)
+		return w
+	}
+
+	return a, nil
+}
+
+// Name returns name of the algorithm
+func (a *flateAlgorithm) Name() string {
+	return "flate"
+}
+
+// Compress compresses data with deflate
+func (a *flateAlgorithm) Compress(data []byte) ([]byte, error) {
+	w := a.writers.Get().(*flate.Writer)
+	defer a.writers.Put(w)
+
+	var buf bytes.Buffer
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func inflate(data []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	return readLimited(r, limit)
+}
+
+// readLimited reads at most limit bytes, so a small value can't decompress into an unbounded allocation
+func readLimited(r io.Reader, limit int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > limit {
+		return nil, errors.Wrapf(ErrValueTooLarge, "limit is %d bytes", limit)
+	}
+
+	return data, nil
+}
+
+func (a *flateAlgorithm) header() byte {
+	return headerFlate
+}
+
+// decompress decodes the value by its header up to limit bytes, data without a known header is returned as is
+func decompress(data []byte, limit int) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	var (
+		result []byte
+		err    error
+	)
+
+	switch data[0] {
+	case headerRaw:
+		return data[1:], nil
+	case headerFlate:
+		result, err = inflate(data[1:], limit)
+	case headerGzip:
+		result, err = gunzip(data[1:], limit)
+	default:
+		return data, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress value")
+	}
+
+	return result, nil
+}
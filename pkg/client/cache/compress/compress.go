@@ -0,0 +1,257 @@
+package compress
+
+import (
+	"compress/gzip"
+	"context"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	"github.com/andredubov/golibs/pkg/client/cache/metrics"
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const (
+	defaultThreshold       = 1024
+	defaultMaxDecompressed = 64 << 20
+)
+
+// Option configures a compressing cache
+type Option func(c *compressed)
+
+// WithThreshold compresses only values of at least threshold bytes
+func WithThreshold(threshold int) Option {
+	return func(c *compressed) {
+		c.threshold = threshold
+	}
+}
+
+// WithAlgorithm sets the algorithm of compression, gzip with default level is used by default
+func WithAlgorithm(algorithm Algorithm) Option {
+	return func(c *compressed) {
+		c.algorithm = algorithm
+	}
+}
+
+// WithMaxDecompressedSize limits the size of a decompressed value, 64 MiB by default,
+// reads of larger values fail with ErrValueTooLarge
+func WithMaxDecompressedSize(size int) Option {
+	return func(c *compressed) {
+		if size > 0 {
+			c.maxDecompressed = size
+		}
+	}
+}
+
+// WithMetrics records sizes of values before and after compression
+func WithMetrics(m metrics.CompressionMetrics) Option {
+	return func(c *compressed) {
+		c.metrics = m
+	}
+}
+
+// compressed stores large string and []byte values compressed, a header byte marks
+// compressed values so that reads decompress them transparently. Values written
+// around the decorator must not start with bytes 0xf5-0xf7 (they never do in UTF-8 text)
+type compressed struct {
+	cache.Cache
+	threshold       int
+	maxDecompressed int
+	algorithm       Algorithm
+	metrics         metrics.CompressionMetrics
+}
+
+// New returns a compressing decorator of the cache
+func New(c cache.Cache, opts ...Option) cache.Cache {
+	algorithm, _ := Gzip(gzip.DefaultCompression)
+
+	cc := &compressed{
+		Cache:           c,
+		threshold:       defaultThreshold,
+		maxDecompressed: defaultMaxDecompressed,
+		algorithm:       algorithm,
+	}
+
+	for _, opt := range opts {
+		opt(cc)
+	}
+
+	return cc
+}
+
+// Set binds a key and a value compressing large values
+func (c *compressed) Set(ctx context.Context, key string, value interface{}) error {
+	encoded, err := c.encode(value)
+	if err != nil {
+		return err
+	}
+
+	return c.Cache.Set(ctx, key, encoded)
+}
+
+// SetWithOptions binds a key and a value compressing large values, the previous value is decompressed
+func (c *compressed) SetWithOptions(ctx context.Context, key string, value interface{}, opts cache.SetOptions) (bool, interface{}, error) {
+	encoded, err := c.encode(value)
+	if err != nil {
+		return false, nil, err
+	}
+
+	written, previous, err := c.Cache.SetWithOptions(ctx, key, encoded, opts)
+	if err != nil {
+		return written, previous, err
+	}
+
+	previous, err = c.decode(previous)
+
+	return written, previous, err
+}
+
+// Get returns a decompressed value by the key
+func (c *compressed) Get(ctx context.Context, key string) (interface{}, error) {
+	value, err := c.Cache.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.decode(value)
+}
+
+// MSet binds keys and their values compressing large values
+func (c *compressed) MSet(ctx context.Context, values map[string]interface{}) error {
+	encoded := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		var err error
+		if encoded[key], err = c.encode(value); err != nil {
+			return err
+		}
+	}
+
+	return c.Cache.MSet(ctx, encoded)
+}
+
+// MGet returns decompressed values by their keys
+func (c *compressed) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	values, err := c.Cache.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.decodeAll(values)
+}
+
+// HashSet binds hash fields and their values compressing large values
+func (c *compressed) HashSet(ctx context.Context, key string, values interface{}) error {
+	args := redigo.Args{}.AddFlat(values)
+	for i := 1; i < len(args); i += 2 {
+		var err error
+		if args[i], err = c.encode(args[i]); err != nil {
+			return err
+		}
+	}
+
+	return c.Cache.HashSet(ctx, key, args)
+}
+
+// HashGetAll returns fields and decompressed values of the hash
+func (c *compressed) HashGetAll(ctx context.Context, key string) ([]interface{}, error) {
+	values, err := c.Cache.HashGetAll(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < len(values); i += 2 {
+		if values[i], err = c.decode(values[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// HashGet returns the decompressed value of the hash field
+func (c *compressed) HashGet(ctx context.Context, key string, field string) (interface{}, error) {
+	value, err := c.Cache.HashGet(ctx, key, field)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.decode(value)
+}
+
+// HashMGet returns decompressed values of the hash fields
+func (c *compressed) HashMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error) {
+	values, err := c.Cache.HashMGet(ctx, key, fields...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.decodeAll(values)
+}
+
+// HashScanStruct reads the decompressed hash into fields of the struct pointed by dest
+func (c *compressed) HashScanStruct(ctx context.Context, key string, dest interface{}) error {
+	values, err := c.HashGetAll(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return redigo.ScanStruct(values, dest)
+}
+
+// encode compresses large values if it pays off, small values starting with a header byte are escaped
+func (c *compressed) encode(value interface{}) (interface{}, error) {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return value, nil
+	}
+
+	if len(data) >= c.threshold {
+		packed, err := c.algorithm.Compress(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.metrics != nil {
+			c.metrics.ObserveCompression(c.algorithm.Name(), len(data), len(packed)+1)
+		}
+
+		if len(packed)+1 < len(data) {
+			return append([]byte{c.algorithm.header()}, packed...), nil
+		}
+	}
+
+	if len(data) != 0 && data[0] >= headerRaw && data[0] <= headerGzip {
+		return append([]byte{headerRaw}, data...), nil
+	}
+
+	return value, nil
+}
+
+func (c *compressed) decode(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case []byte:
+		return decompress(v, c.maxDecompressed)
+	case string:
+		data, err := decompress([]byte(v), c.maxDecompressed)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	default:
+		return value, nil
+	}
+}
+
+func (c *compressed) decodeAll(values []interface{}) ([]interface{}, error) {
+	for i, value := range values {
+		var err error
+		if values[i], err = c.decode(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// GetString returns the value of the key as a string, ErrNotFound if the key doesn't exist
+func GetString(ctx context.Context, c Cache, key string) (string, error) {
+	value, err := c.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	switch v := value.(type) {
+	case []byte:
+		return string(v), nil
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	default:
+		return "", fmt.Errorf("unexpected type %T of key %q", value, key)
+	}
+}
+
+// GetBytes returns the value of the key as a byte slice, ErrNotFound if the key doesn't exist
+func GetBytes(ctx context.Context, c Cache, key string) ([]byte, error) {
+	value, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	default:
+		return nil, fmt.Errorf("unexpected type %T of key %q", value, key)
+	}
+}
+
+// GetInt64 returns the value of the key parsed as an integer, ErrNotFound if the key doesn't exist
+func GetInt64(ctx context.Context, c Cache, key string) (int64, error) {
+	value, err := GetString(ctx, c, key)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse value of key %q: %w", key, err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,187 @@
+package loader
+
+import (
+	"context"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	"github.com/andredubov/golibs/pkg/client/cache/typed"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	lockKeyPrefix        = "lock:"
+	defaultLockTTL       = 5 * time.Second
+	defaultRetryInterval = 50 * time.Millisecond
+	defaultLoadTimeout   = 10 * time.Second
+)
+
+// ErrLockNotAcquired is returned by Locker when the lock is held by someone else
+var ErrLockNotAcquired = errors.New("lock is held by another owner")
+
+// LoadFunc loads a value from the source of truth on cache miss
+type LoadFunc[T any] func(ctx context.Context) (T, error)
+
+// UnlockFunc releases an acquired lock
+type UnlockFunc func(ctx context.Context) error
+
+// Locker serializes loads of the same key across instances
+type Locker interface {
+	TryLock(ctx context.Context, key string, ttl time.Duration) (UnlockFunc, error)
+}
+
+// Loader reads values through the cache loading them on miss
+type Loader[T any] interface {
+	GetOrLoad(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (T, error)
+}
+
+// Option configures a loader
+type Option func(o *options)
+
+type options struct {
+	locker        Locker
+	lockTTL       time.Duration
+	retryInterval time.Duration
+	loadTimeout   time.Duration
+}
+
+// WithLoadTimeout bounds a shared load, it isn't canceled by callers giving up since others may wait for it
+func WithLoadTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.loadTimeout = timeout
+		}
+	}
+}
+
+// WithLocker protects loads across instances with a short lock, other instances
+// poll the cache every retryInterval while the lock is held
+func WithLocker(locker Locker, lockTTL, retryInterval time.Duration) Option {
+	return func(o *options) {
+		o.locker = locker
+		if lockTTL > 0 {
+			o.lockTTL = lockTTL
+		}
+		if retryInterval > 0 {
+			o.retryInterval = retryInterval
+		}
+	}
+}
+
+type loader[T any] struct {
+	cache   typed.Typed[T]
+	group   singleflight.Group
+	options options
+}
+
+// New returns a new cache-aside loader storing values encoded by codec
+func New[T any](c cache.Cache, codec typed.Codec, opts ...Option) Loader[T] {
+	o := options{
+		lockTTL:       defaultLockTTL,
+		retryInterval: defaultRetryInterval,
+		loadTimeout:   defaultLoadTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &loader[T]{
+		cache:   typed.New[T](c, codec),
+		options: o,
+	}
+}
+
+// GetOrLoad returns value by its key from cache, on miss the value is loaded once
+// for all concurrent callers and stored into cache with ttl
+func (l *loader[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (T, error) {
+	value, err := l.cache.Get(ctx, key)
+	if err == nil {
+		return value, nil
+	}
+
+	if !errors.Is(err, typed.ErrNotFound) {
+		return value, errors.Wrapf(err, "failed to get key %q from cache", key)
+	}
+
+	// the load is shared by all concurrent callers, so it doesn't depend on the cancellation of the first one,
+	// every caller stops waiting on its own context instead
+	results := l.group.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), l.options.loadTimeout)
+		defer cancel()
+
+		if l.options.locker != nil {
+			return l.loadLocked(ctx, key, ttl, load)
+		}
+
+		return l.load(ctx, key, ttl, load)
+	})
+
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return value, result.Err
+		}
+
+		// a nil interface value can't be asserted to T, it's returned as zero value
+		value, _ = result.Val.(T)
+
+		return value, nil
+	case <-ctx.Done():
+		return value, ctx.Err()
+	}
+}
+
+func (l *loader[T]) load(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (T, error) {
+	value, err := load(ctx)
+	if err != nil {
+		return value, err
+	}
+
+	if err = l.cache.SetWithTTL(ctx, key, value, ttl); err != nil {
+		return value, errors.Wrapf(err, "failed to set key %q into cache", key)
+	}
+
+	return value, nil
+}
+
+func (l *loader[T]) loadLocked(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (T, error) {
+	deadline := time.Now().Add(l.options.lockTTL)
+
+	for {
+		unlock, err := l.options.locker.TryLock(ctx, lockKeyPrefix+key, l.options.lockTTL)
+		if err == nil {
+			defer func() {
+				_ = unlock(ctx)
+			}()
+
+			// another instance may have loaded the value while we were waiting for the lock
+			value, err := l.cache.Get(ctx, key)
+			if err == nil || !errors.Is(err, typed.ErrNotFound) {
+				return value, err
+			}
+
+			return l.load(ctx, key, ttl, load)
+		}
+
+		if !errors.Is(err, ErrLockNotAcquired) {
+			return *new(T), errors.Wrapf(err, "failed to lock key %q", key)
+		}
+
+		// the holder didn't fill the cache in time, load the value ourselves
+		if time.Now().After(deadline) {
+			return l.load(ctx, key, ttl, load)
+		}
+
+		select {
+		case <-ctx.Done():
+			return *new(T), ctx.Err()
+		case <-time.After(l.options.retryInterval):
+		}
+
+		value, err := l.cache.Get(ctx, key)
+		if err == nil || !errors.Is(err, typed.ErrNotFound) {
+			return value, err
+		}
+	}
+}
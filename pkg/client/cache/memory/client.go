@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+)
+
+type memoryClient struct {
+	masterCache cache.Cache
+}
+
+// New returns a new instance of memoryClient struct, the cache holds at most maxEntries keys
+// (zero means unbounded) and removes expired keys every cleanupInterval
+func New(maxEntries int, cleanupInterval time.Duration) cache.Client {
+	return &memoryClient{
+		masterCache: NewCache(maxEntries, cleanupInterval),
+	}
+}
+
+// Cache returns cache
+func (m *memoryClient) Cache() cache.Cache {
+	return m.masterCache
+}
+
+// Stats returns zero statistics, the in-memory cache has no connections
+func (m *memoryClient) Stats() cache.PoolStats {
+	return cache.PoolStats{}
+}
+
+// Close cache
+func (m *memoryClient) Close() error {
+	if m.masterCache != nil {
+		return m.masterCache.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,769 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+const (
+	// ErrWrongType mirrors the redis error for operations against a key holding the wrong kind of value
+	ErrWrongType = redigo.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	// ErrClosed is returned by operations on a closed cache
+	ErrClosed = redigo.Error("ERR cache is closed")
+)
+
+// errWrongType matches both ErrWrongType and cache.ErrWrongType
+var errWrongType = fmt.Errorf("%w: %w", cache.ErrWrongType, ErrWrongType)
+
+type entry struct {
+	key       string
+	value     []byte
+	hash      *hash
+	expiresAt time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// hash keeps fields in insertion order like small redis hashes do
+type hash struct {
+	fields    []string
+	values    map[string][]byte
+	expiresAt map[string]time.Time
+}
+
+// set writes the field value, like HSET it removes the field expiration
+func (h *hash) set(field string, value []byte) {
+	if _, ok := h.values[field]; !ok {
+		h.fields = append(h.fields, field)
+	}
+	h.values[field] = value
+	delete(h.expiresAt, field)
+}
+
+func (h *hash) get(field string) ([]byte, bool) {
+	value, ok := h.values[field]
+
+	return value, ok
+}
+
+func (h *hash) del(field string) bool {
+	if _, ok := h.values[field]; !ok {
+		return false
+	}
+
+	delete(h.values, field)
+	delete(h.expiresAt, field)
+	for i, f := range h.fields {
+		if f == field {
+			h.fields = append(h.fields[:i], h.fields[i+1:]...)
+			break
+		}
+	}
+
+	return true
+}
+
+// deleteExpired removes fields whose expiration has passed
+func (h *hash) deleteExpired(now time.Time) {
+	for field, expiresAt := range h.expiresAt {
+		if !now.Before(expiresAt) {
+			h.del(field)
+		}
+	}
+}
+
+type memoryCache struct {
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List
+	maxEntries int
+	closed     bool
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewCache returns a new instance of in-memory cache, the cache holds at most maxEntries keys
+// evicting least recently used ones (zero means unbounded) and removes expired keys every cleanupInterval
+func NewCache(maxEntries int, cleanupInterval time.Duration) cache.Cache {
+	m := &memoryCache{
+		items:      make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: maxEntries,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	if cleanupInterval > 0 {
+		go m.janitor(cleanupInterval)
+	} else {
+		close(m.done)
+	}
+
+	return m
+}
+
+// Set binds key and its value
+func (m *memoryCache) Set(ctx context.Context, key string, value interface{}) error {
+	_, _, err := m.SetWithOptions(ctx, key, value, cache.SetOptions{})
+
+	return err
+}
+
+// SetWithOptions binds key and its value applying expiration and write condition
+func (m *memoryCache) SetWithOptions(_ context.Context, key string, value interface{}, opts cache.SetOptions) (bool, interface{}, error) {
+	if err := opts.Validate(); err != nil {
+		return false, nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false, nil, ErrClosed
+	}
+
+	now := time.Now()
+	e := m.lookup(key, now)
+
+	var previous interface{}
+	if e != nil && opts.Get {
+		if e.hash != nil {
+			return false, nil, errWrongType
+		}
+		previous = e.value
+	}
+
+	if (opts.Mode == cache.SetIfNotExists && e != nil) || (opts.Mode == cache.SetIfExists && e == nil) {
+		return false, previous, nil
+	}
+
+	var expiresAt time.Time
+	switch {
+	case opts.TTL > 0:
+		expiresAt = now.Add(opts.TTL)
+	case opts.KeepTTL && e != nil:
+		expiresAt = e.expiresAt
+	}
+
+	m.store(&entry{key: key, value: toBytes(value), expiresAt: expiresAt})
+
+	return true, previous, nil
+}
+
+// Get returns value by its key from cache, cache.ErrNotFound if the key doesn't exist
+func (m *memoryCache) Get(_ context.Context, key string) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	e := m.lookup(key, time.Now())
+	if e == nil {
+		return nil, cache.ErrNotFound
+	}
+
+	if e.hash != nil {
+		return nil, errWrongType
+	}
+
+	return e.value, nil
+}
+
+// MSet binds keys and their values atomically
+func (m *memoryCache) MSet(_ context.Context, values map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	for key, value := range values {
+		m.store(&entry{key: key, value: toBytes(value)})
+	}
+
+	return nil
+}
+
+// MGet returns values by their keys from cache, missing keys and keys holding hashes have nil values
+func (m *memoryCache) MGet(_ context.Context, keys ...string) ([]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now()
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if e := m.lookup(key, now); e != nil && e.hash == nil {
+			values[i] = e.value
+		}
+	}
+
+	return values, nil
+}
+
+// MDelete deletes values by their keys into cache
+func (m *memoryCache) MDelete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	for _, key := range keys {
+		if element, ok := m.items[key]; ok {
+			m.remove(element)
+		}
+	}
+
+	return nil
+}
+
+// HashSet binds key and value pair to the hash into cache
+func (m *memoryCache) HashSet(_ context.Context, key string, values interface{}) error {
+	args := redigo.Args{}.AddFlat(values)
+	if len(args) == 0 || len(args)%2 != 0 {
+		return redigo.Error("ERR wrong number of arguments for 'hset' command")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	e := m.lookup(key, time.Now())
+	if e == nil {
+		e = &entry{key: key, hash: &hash{values: make(map[string][]byte)}}
+		m.store(e)
+	}
+
+	if e.hash == nil {
+		return errWrongType
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		e.hash.set(string(toBytes(args[i])), toBytes(args[i+1]))
+	}
+
+	return nil
+}
+
+// HashGetAll returns a sequence of key-value pairs of the corresponding hash, cache.ErrNotFound if the hash doesn't exist
+func (m *memoryCache) HashGetAll(_ context.Context, key string) ([]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	e, err := m.lookupHash(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if e == nil {
+		return nil, cache.ErrNotFound
+	}
+
+	values := make([]interface{}, 0, len(e.hash.fields)*2)
+	for _, field := range e.hash.fields {
+		values = append(values, []byte(field), e.hash.values[field])
+	}
+
+	return values, nil
+}
+
+// HashGet returns the value of the hash field, cache.ErrNotFound if the field doesn't exist
+func (m *memoryCache) HashGet(_ context.Context, key string, field string) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	e, err := m.lookupHash(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if e != nil {
+		if value, ok := e.hash.get(field); ok {
+			return value, nil
+		}
+	}
+
+	return nil, cache.ErrNotFound
+}
+
+// HashMGet returns values of the hash fields, nil for missing ones
+func (m *memoryCache) HashMGet(_ context.Context, key string, fields ...string) ([]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	e, err := m.lookupHash(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(fields))
+	if e == nil {
+		return values, nil
+	}
+
+	for i, field := range fields {
+		if value, ok := e.hash.get(field); ok {
+			values[i] = value
+		}
+	}
+
+	return values, nil
+}
+
+// HashDel deletes the hash fields, returns the number of deleted fields
+func (m *memoryCache) HashDel(_ context.Context, key string, fields ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return 0, ErrClosed
+	}
+
+	e, err := m.lookupHash(key, time.Now())
+	if err != nil || e == nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, field := range fields {
+		if e.hash.del(field) {
+			deleted++
+		}
+	}
+
+	m.removeEmptyHash(e)
+
+	return deleted, nil
+}
+
+// HashIncrBy increments the integer value of the hash field, returns the value after the increment
+func (m *memoryCache) HashIncrBy(_ context.Context, key string, field string, increment int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return 0, ErrClosed
+	}
+
+	e, err := m.lookupHash(key, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	if e == nil {
+		e = &entry{key: key, hash: &hash{values: make(map[string][]byte)}}
+		m.store(e)
+	}
+
+	var current int64
+	if value, ok := e.hash.get(field); ok {
+		current, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, redigo.Error("ERR hash value is not an integer")
+		}
+	}
+
+	if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+		return 0, redigo.Error("ERR increment or decrement would overflow")
+	}
+
+	current += increment
+
+	// unlike HSET, HINCRBY retains the field expiration
+	expiresAt, hasExpiration := e.hash.expiresAt[field]
+	e.hash.set(field, strconv.AppendInt(nil, current, 10))
+	if hasExpiration {
+		e.hash.expiresAt[field] = expiresAt
+	}
+
+	return current, nil
+}
+
+// HashExists reports whether the hash field exists
+func (m *memoryCache) HashExists(_ context.Context, key string, field string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false, ErrClosed
+	}
+
+	e, err := m.lookupHash(key, time.Now())
+	if err != nil || e == nil {
+		return false, err
+	}
+
+	_, ok := e.hash.get(field)
+
+	return ok, nil
+}
+
+// HashScanStruct reads the hash into fields of the struct pointed by dest, see redigo.ScanStruct for `redis:"field"` tags
+func (m *memoryCache) HashScanStruct(ctx context.Context, key string, dest interface{}) error {
+	values, err := m.HashGetAll(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return redigo.ScanStruct(values, dest)
+}
+
+// HashExpire sets time to expire the hash fields, reports for each field whether it exists,
+// a non-positive expiration deletes the fields
+func (m *memoryCache) HashExpire(_ context.Context, key string, expiration time.Duration, fields ...string) ([]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now()
+	e, err := m.lookupHash(key, now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]bool, len(fields))
+	if e == nil {
+		return result, nil
+	}
+
+	for i, field := range fields {
+		if _, ok := e.hash.get(field); !ok {
+			continue
+		}
+
+		result[i] = true
+		if expiration <= 0 {
+			e.hash.del(field)
+			continue
+		}
+
+		if e.hash.expiresAt == nil {
+			e.hash.expiresAt = make(map[string]time.Time)
+		}
+		e.hash.expiresAt[field] = now.Add(expiration)
+	}
+
+	m.removeEmptyHash(e)
+
+	return result, nil
+}
+
+// HashTTL returns remaining time to live of the hash fields, cache.NoExpiration or cache.KeyNotExists for each field
+func (m *memoryCache) HashTTL(_ context.Context, key string, fields ...string) ([]time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now()
+	e, err := m.lookupHash(key, now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]time.Duration, len(fields))
+	for i, field := range fields {
+		if e == nil {
+			result[i] = cache.KeyNotExists
+			continue
+		}
+
+		expiresAt, hasExpiration := e.hash.expiresAt[field]
+		_, ok := e.hash.get(field)
+		switch {
+		case !ok:
+			result[i] = cache.KeyNotExists
+		case !hasExpiration:
+			result[i] = cache.NoExpiration
+		default:
+			result[i] = expiresAt.Sub(now).Round(time.Millisecond)
+		}
+	}
+
+	return result, nil
+}
+
+// Expire sets time to expire key into cache
+func (m *memoryCache) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	_, err := m.PExpire(ctx, key, expiration)
+
+	return err
+}
+
+// PExpire sets time to expire key into cache, reports whether the key exists
+func (m *memoryCache) PExpire(_ context.Context, key string, expiration time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false, ErrClosed
+	}
+
+	now := time.Now()
+	e := m.lookup(key, now)
+	if e == nil {
+		return false, nil
+	}
+
+	// like redis, a non-positive expiration deletes the key
+	if expiration <= 0 {
+		m.remove(m.items[key])
+		return true, nil
+	}
+
+	e.expiresAt = now.Add(expiration)
+
+	return true, nil
+}
+
+// TTL returns remaining time to live of the key or cache.NoExpiration, cache.ErrNotFound if the key doesn't exist
+func (m *memoryCache) TTL(_ context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return 0, ErrClosed
+	}
+
+	now := time.Now()
+	e := m.lookup(key, now)
+	switch {
+	case e == nil:
+		return 0, cache.ErrNotFound
+	case e.expiresAt.IsZero():
+		return cache.NoExpiration, nil
+	default:
+		return e.expiresAt.Sub(now).Round(time.Millisecond), nil
+	}
+}
+
+// Persist removes expiration of the key, reports whether the expiration was removed
+func (m *memoryCache) Persist(_ context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false, ErrClosed
+	}
+
+	e := m.lookup(key, time.Now())
+	if e == nil || e.expiresAt.IsZero() {
+		return false, nil
+	}
+
+	e.expiresAt = time.Time{}
+
+	return true, nil
+}
+
+// Delete a value by its key into cache
+func (m *memoryCache) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	if element, ok := m.items[key]; ok {
+		m.remove(element)
+	}
+
+	return nil
+}
+
+// Ping tests cache availability
+func (m *memoryCache) Ping(_ context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// Close stops the janitor and drops all keys
+func (m *memoryCache) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+
+	m.closed = true
+	m.items = make(map[string]*list.Element)
+	m.lru.Init()
+	close(m.stop)
+	m.mu.Unlock()
+
+	<-m.done
+
+	return nil
+}
+
+// lookup returns a live entry marking it as recently used, expired entries are removed
+func (m *memoryCache) lookup(key string, now time.Time) *entry {
+	element, ok := m.items[key]
+	if !ok {
+		return nil
+	}
+
+	e := element.Value.(*entry)
+	if e.expired(now) {
+		m.remove(element)
+		return nil
+	}
+
+	m.lru.MoveToFront(element)
+
+	return e
+}
+
+// lookupHash returns a live hash entry without expired fields, nil if the key doesn't exist
+func (m *memoryCache) lookupHash(key string, now time.Time) (*entry, error) {
+	e := m.lookup(key, now)
+	if e == nil {
+		return nil, nil
+	}
+
+	if e.hash == nil {
+		return nil, errWrongType
+	}
+
+	e.hash.deleteExpired(now)
+	if m.removeEmptyHash(e) {
+		return nil, nil
+	}
+
+	return e, nil
+}
+
+// removeEmptyHash removes the key of the hash without fields like redis does
+func (m *memoryCache) removeEmptyHash(e *entry) bool {
+	if len(e.hash.fields) != 0 {
+		return false
+	}
+
+	if element, ok := m.items[e.key]; ok {
+		m.remove(element)
+	}
+
+	return true
+}
+
+// store puts the entry replacing an existing one and evicts least recently used entries
+func (m *memoryCache) store(e *entry) {
+	if element, ok := m.items[e.key]; ok {
+		element.Value = e
+		m.lru.MoveToFront(element)
+		return
+	}
+
+	m.items[e.key] = m.lru.PushFront(e)
+
+	for m.maxEntries > 0 && m.lru.Len() > m.maxEntries {
+		m.remove(m.lru.Back())
+	}
+}
+
+func (m *memoryCache) remove(element *list.Element) {
+	m.lru.Remove(element)
+	delete(m.items, element.Value.(*entry).key)
+}
+
+func (m *memoryCache) janitor(interval time.Duration) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.deleteExpired()
+		}
+	}
+}
+
+func (m *memoryCache) deleteExpired() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for element := m.lru.Back(); element != nil; {
+		prev := element.Prev()
+		if element.Value.(*entry).expired(now) {
+			m.remove(element)
+		}
+		element = prev
+	}
+}
+
+// toBytes converts a value the same way redigo writes command arguments
+func toBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return []byte{}
+	case []byte:
+		return append([]byte(nil), v...)
+	case string:
+		return []byte(v)
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(nil, v, 10)
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64)
+	case bool:
+		if v {
+			return []byte("1")
+		}
+		return []byte("0")
+	case redigo.Argument:
+		return toBytes(v.RedisArg())
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+)
+
+// PoolStats is a snapshot of connection pool statistics
+type PoolStats = cache.PoolStats
+
+// Metrics interface for recording cache performance
+type Metrics interface {
+	// ObserveCommand records latency of a command
+	ObserveCommand(command string, duration time.Duration)
+	// IncHit counts a read command that found its key
+	IncHit(command string)
+	// IncMiss counts a read command that didn't find its key
+	IncMiss(command string)
+	// IncError counts a failed command by the kind of error
+	IncError(command string, kind string)
+}
+
+// PoolStatsCollector is implemented by metrics reading pool statistics when they are scraped,
+// so commands don't have to lock the pools to report them
+type PoolStatsCollector interface {
+	// CollectPoolStats registers a source of pool statistics, statistics of all sources are summed
+	CollectPoolStats(source func() PoolStats)
+}
+
+// CompressionMetrics interface for recording efficiency of value compression
+type CompressionMetrics interface {
+	// ObserveCompression records sizes of a value before and after compression
+	ObserveCompression(algorithm string, originalSize, compressedSize int)
+}
+
+type nop struct{}
+
+// Nop returns metrics discarding everything
+func Nop() Metrics {
+	return nop{}
+}
+
+// ObserveCommand does nothing
+func (nop) ObserveCommand(string, time.Duration) {}
+
+// IncHit does nothing
+func (nop) IncHit(string) {}
+
+// IncMiss does nothing
+func (nop) IncMiss(string) {}
+
+// IncError does nothing
+func (nop) IncError(string, string) {}
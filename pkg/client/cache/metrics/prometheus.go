@@ -0,0 +1,330 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are latency histogram buckets in seconds
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// ratioBuckets are buckets of compressed to original size ratio
+var ratioBuckets = []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(buckets []float64, value float64) {
+	for i, bound := range buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// snapshot is a copy of collected metrics written without holding the lock
+type snapshot struct {
+	latencies  map[string]histogram
+	hits       map[string]uint64
+	misses     map[string]uint64
+	errors     map[errorKey]uint64
+	pools      []func() PoolStats
+	ratios     map[string]histogram
+	original   map[string]uint64
+	compressed map[string]uint64
+}
+
+func (h *histogram) clone() histogram {
+	return histogram{counts: append([]uint64(nil), h.counts...), count: h.count, sum: h.sum}
+}
+
+type errorKey struct {
+	command string
+	kind    string
+}
+
+// Prometheus collects metrics and exposes them in prometheus text format
+type Prometheus struct {
+	namespace  string
+	buckets    []float64
+	mu         sync.Mutex
+	latencies  map[string]*histogram
+	hits       map[string]uint64
+	misses     map[string]uint64
+	errors     map[errorKey]uint64
+	pools      []func() PoolStats
+	ratios     map[string]*histogram
+	original   map[string]uint64
+	compressed map[string]uint64
+}
+
+// NewPrometheus returns a new collector, metric names are prefixed with namespace,
+// DefaultBuckets are used if buckets are not specified
+func NewPrometheus(namespace string, buckets ...float64) *Prometheus {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	return &Prometheus{
+		namespace:  namespace,
+		buckets:    sorted,
+		latencies:  make(map[string]*histogram),
+		hits:       make(map[string]uint64),
+		misses:     make(map[string]uint64),
+		errors:     make(map[errorKey]uint64),
+		ratios:     make(map[string]*histogram),
+		original:   make(map[string]uint64),
+		compressed: make(map[string]uint64),
+	}
+}
+
+// ObserveCommand records latency of a command
+func (p *Prometheus) ObserveCommand(command string, duration time.Duration) {
+	seconds := duration.Seconds()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, ok := p.latencies[command]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		p.latencies[command] = h
+	}
+
+	h.observe(p.buckets, seconds)
+}
+
+// IncHit counts a read command that found its key
+func (p *Prometheus) IncHit(command string) {
+	p.mu.Lock()
+	p.hits[command]++
+	p.mu.Unlock()
+}
+
+// IncMiss counts a read command that didn't find its key
+func (p *Prometheus) IncMiss(command string) {
+	p.mu.Lock()
+	p.misses[command]++
+	p.mu.Unlock()
+}
+
+// IncError counts a failed command by the kind of error
+func (p *Prometheus) IncError(command string, kind string) {
+	p.mu.Lock()
+	p.errors[errorKey{command, kind}]++
+	p.mu.Unlock()
+}
+
+// CollectPoolStats registers a source of pool statistics read on every scrape
+func (p *Prometheus) CollectPoolStats(source func() PoolStats) {
+	p.mu.Lock()
+	p.pools = append(p.pools, source)
+	p.mu.Unlock()
+}
+
+// ObserveCompression records sizes of a value before and after compression
+func (p *Prometheus) ObserveCompression(algorithm string, originalSize, compressedSize int) {
+	if originalSize <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, ok := p.ratios[algorithm]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(ratioBuckets))}
+		p.ratios[algorithm] = h
+	}
+
+	h.observe(ratioBuckets, float64(compressedSize)/float64(originalSize))
+	p.original[algorithm] += uint64(originalSize)
+	p.compressed[algorithm] += uint64(compressedSize)
+}
+
+// ServeHTTP writes metrics in prometheus text format
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	_, _ = p.WriteTo(w)
+}
+
+// WriteTo writes metrics in prometheus text format, the collector isn't locked while writing to a slow w
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	s := p.snapshot()
+
+	bw := &countingWriter{w: bufio.NewWriter(w)}
+
+	name := p.name("command_duration_seconds")
+	bw.printf("# HELP %s Latency of cache commands.\n# TYPE %s histogram\n", name, name)
+	for _, command := range sortedKeys(s.latencies) {
+		h := s.latencies[command]
+		for i, bound := range p.buckets {
+			bw.printf("%s_bucket{command=%q,le=%q} %d\n", name, command, formatFloat(bound), h.counts[i])
+		}
+		bw.printf("%s_bucket{command=%q,le=\"+Inf\"} %d\n", name, command, h.count)
+		bw.printf("%s_sum{command=%q} %s\n", name, command, formatFloat(h.sum))
+		bw.printf("%s_count{command=%q} %d\n", name, command, h.count)
+	}
+
+	p.writeCounter(bw, "hits_total", "Read commands that found their keys.", s.hits)
+	p.writeCounter(bw, "misses_total", "Read commands that didn't find their keys.", s.misses)
+
+	name = p.name("errors_total")
+	bw.printf("# HELP %s Failed cache commands by kind of error.\n# TYPE %s counter\n", name, name)
+	errorKeys := make([]errorKey, 0, len(s.errors))
+	for key := range s.errors {
+		errorKeys = append(errorKeys, key)
+	}
+	sort.Slice(errorKeys, func(i, j int) bool {
+		if errorKeys[i].command != errorKeys[j].command {
+			return errorKeys[i].command < errorKeys[j].command
+		}
+		return errorKeys[i].kind < errorKeys[j].kind
+	})
+	for _, key := range errorKeys {
+		bw.printf("%s{command=%q,kind=%q} %d\n", name, key.command, key.kind, s.errors[key])
+	}
+
+	if len(s.pools) != 0 {
+		// pools are read after the collector is unlocked, they have locks of their own
+		var pool PoolStats
+		for _, source := range s.pools {
+			stats := source()
+			pool.ActiveCount += stats.ActiveCount
+			pool.IdleCount += stats.IdleCount
+			pool.WaitCount += stats.WaitCount
+			pool.WaitDuration += stats.WaitDuration
+		}
+
+		p.writeSingle(bw, "pool_active_connections", "gauge", "Connections in the pool including idle ones.", strconv.Itoa(pool.ActiveCount))
+		p.writeSingle(bw, "pool_idle_connections", "gauge", "Idle connections in the pool.", strconv.Itoa(pool.IdleCount))
+		p.writeSingle(bw, "pool_wait_total", "counter", "Connections waited for.", strconv.FormatInt(pool.WaitCount, 10))
+		p.writeSingle(bw, "pool_wait_seconds_total", "counter", "Time blocked waiting for connections.", formatFloat(pool.WaitDuration.Seconds()))
+	}
+
+	if len(s.ratios) != 0 {
+		name = p.name("compression_ratio")
+		bw.printf("# HELP %s Ratio of compressed to original size of values.\n# TYPE %s histogram\n", name, name)
+		for _, algorithm := range sortedKeys(s.ratios) {
+			h := s.ratios[algorithm]
+			for i, bound := range ratioBuckets {
+				bw.printf("%s_bucket{algorithm=%q,le=%q} %d\n", name, algorithm, formatFloat(bound), h.counts[i])
+			}
+			bw.printf("%s_bucket{algorithm=%q,le=\"+Inf\"} %d\n", name, algorithm, h.count)
+			bw.printf("%s_sum{algorithm=%q} %s\n", name, algorithm, formatFloat(h.sum))
+			bw.printf("%s_count{algorithm=%q} %d\n", name, algorithm, h.count)
+		}
+
+		p.writeAlgorithmCounter(bw, "compression_original_bytes_total", "Size of values before compression.", s.original)
+		p.writeAlgorithmCounter(bw, "compression_compressed_bytes_total", "Size of values after compression.", s.compressed)
+	}
+
+	if bw.err == nil {
+		bw.err = bw.w.Flush()
+	}
+
+	return bw.n, bw.err
+}
+
+func (p *Prometheus) snapshot() snapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := snapshot{
+		latencies:  make(map[string]histogram, len(p.latencies)),
+		hits:       maps.Clone(p.hits),
+		misses:     maps.Clone(p.misses),
+		errors:     maps.Clone(p.errors),
+		pools:      append([]func() PoolStats(nil), p.pools...),
+		ratios:     make(map[string]histogram, len(p.ratios)),
+		original:   maps.Clone(p.original),
+		compressed: maps.Clone(p.compressed),
+	}
+
+	for command, h := range p.latencies {
+		s.latencies[command] = h.clone()
+	}
+
+	for algorithm, h := range p.ratios {
+		s.ratios[algorithm] = h.clone()
+	}
+
+	return s
+}
+
+func (p *Prometheus) writeCounter(bw *countingWriter, suffix, help string, values map[string]uint64) {
+	name := p.name(suffix)
+	bw.printf("# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, command := range sortedKeys(values) {
+		bw.printf("%s{command=%q} %d\n", name, command, values[command])
+	}
+}
+
+func (p *Prometheus) writeAlgorithmCounter(bw *countingWriter, suffix, help string, values map[string]uint64) {
+	name := p.name(suffix)
+	bw.printf("# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, algorithm := range sortedKeys(values) {
+		bw.printf("%s{algorithm=%q} %d\n", name, algorithm, values[algorithm])
+	}
+}
+
+func (p *Prometheus) writeSingle(bw *countingWriter, suffix, kind, help, value string) {
+	name := p.name(suffix)
+	bw.printf("# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, value)
+}
+
+func (p *Prometheus) name(suffix string) string {
+	if p.namespace == "" {
+		return suffix
+	}
+
+	return p.namespace + "_" + suffix
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(v float64) string {
+	return strings.TrimSuffix(strconv.FormatFloat(v, 'g', -1, 64), ".0")
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) printf(format string, args ...interface{}) {
+	if cw.err != nil {
+		return
+	}
+
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.n += int64(n)
+	cw.err = err
+}
@@ -0,0 +1,373 @@
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// Namespace is a cache isolating keys of a service, all keys are prefixed with
+// "<service>:v<schema version>:g<generation>:" so that bumping the generation
+// logically invalidates the whole namespace, stale keys are left to expire
+type Namespace interface {
+	cache.Cache
+	// Bump switches the namespace to the next generation and returns it
+	Bump(ctx context.Context) (int64, error)
+	// Generation returns the current generation of the namespace
+	Generation(ctx context.Context) (int64, error)
+}
+
+// generationField is the field of the generation hash, a hash allows atomic HashIncrBy
+const generationField = "value"
+
+type namespace struct {
+	cache           cache.Cache
+	base            string
+	refreshInterval time.Duration
+	refresh         singleflight.Group
+	mu              sync.Mutex
+	generation      int64
+	loaded          bool
+	refreshedAt     time.Time
+}
+
+// New returns a cache prefixing keys with service name and schema version, the generation
+// bumped by other instances is noticed within refreshInterval
+func New(c cache.Cache, service string, schemaVersion int, refreshInterval time.Duration) Namespace {
+	return &namespace{
+		cache:           c,
+		base:            fmt.Sprintf("%s:v%d:", service, schemaVersion),
+		refreshInterval: refreshInterval,
+	}
+}
+
+// Bump switches the namespace to the next generation, the generation is incremented atomically
+// so that concurrent bumps never move it back
+func (n *namespace) Bump(ctx context.Context) (int64, error) {
+	generation, err := n.cache.HashIncrBy(ctx, n.generationKey(), generationField, 1)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to bump namespace generation")
+	}
+
+	n.publish(generation)
+
+	return generation, nil
+}
+
+// Generation returns the current generation of the namespace, concurrent refreshes are collapsed into one
+func (n *namespace) Generation(ctx context.Context) (int64, error) {
+	n.mu.Lock()
+	generation, loaded := n.generation, n.loaded
+	fresh := loaded && time.Since(n.refreshedAt) < n.refreshInterval
+	n.mu.Unlock()
+
+	if fresh {
+		return generation, nil
+	}
+
+	// the refresh is shared, so it must not be canceled by the caller which happened to start it
+	value, err, _ := n.refresh.Do(n.generationKey(), func() (interface{}, error) {
+		generation, err := n.loadGeneration(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		return n.publish(generation), nil
+	})
+	if err != nil {
+		// a stale generation is better than failing every command
+		if loaded {
+			return generation, nil
+		}
+
+		return 0, err
+	}
+
+	return value.(int64), nil
+}
+
+// publish stores the loaded generation, the generation never moves back
+func (n *namespace) publish(generation int64) int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.loaded || generation > n.generation {
+		n.generation = generation
+	}
+	n.loaded, n.refreshedAt = true, time.Now()
+
+	return n.generation
+}
+
+// Set binds key and its value
+func (n *namespace) Set(ctx context.Context, key string, value interface{}) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	return n.cache.Set(ctx, prefix+key, value)
+}
+
+// SetWithOptions binds key and its value applying expiration and write condition
+func (n *namespace) SetWithOptions(ctx context.Context, key string, value interface{}, opts cache.SetOptions) (bool, interface{}, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return false, nil, err
+	}
+
+	return n.cache.SetWithOptions(ctx, prefix+key, value, opts)
+}
+
+// Get returns value by its key from cache
+func (n *namespace) Get(ctx context.Context, key string) (interface{}, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.cache.Get(ctx, prefix+key)
+}
+
+// MSet binds keys and their values
+func (n *namespace) MSet(ctx context.Context, values map[string]interface{}) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	prefixed := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		prefixed[prefix+key] = value
+	}
+
+	return n.cache.MSet(ctx, prefixed)
+}
+
+// MGet returns values by their keys from cache
+func (n *namespace) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.cache.MGet(ctx, prefixed(prefix, keys)...)
+}
+
+// MDelete deletes values by their keys into cache
+func (n *namespace) MDelete(ctx context.Context, keys ...string) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	return n.cache.MDelete(ctx, prefixed(prefix, keys)...)
+}
+
+// HashSet binds key and value pair to the hash into cache
+func (n *namespace) HashSet(ctx context.Context, key string, values interface{}) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	return n.cache.HashSet(ctx, prefix+key, values)
+}
+
+// HashGetAll returns a sequence of key-value pairs of the corresponding hash
+func (n *namespace) HashGetAll(ctx context.Context, key string) ([]interface{}, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.cache.HashGetAll(ctx, prefix+key)
+}
+
+// HashGet returns the value of the hash field
+func (n *namespace) HashGet(ctx context.Context, key string, field string) (interface{}, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.cache.HashGet(ctx, prefix+key, field)
+}
+
+// HashMGet returns values of the hash fields
+func (n *namespace) HashMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.cache.HashMGet(ctx, prefix+key, fields...)
+}
+
+// HashDel deletes the hash fields
+func (n *namespace) HashDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return n.cache.HashDel(ctx, prefix+key, fields...)
+}
+
+// HashIncrBy increments the integer value of the hash field
+func (n *namespace) HashIncrBy(ctx context.Context, key string, field string, increment int64) (int64, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return n.cache.HashIncrBy(ctx, prefix+key, field, increment)
+}
+
+// HashExists reports whether the hash field exists
+func (n *namespace) HashExists(ctx context.Context, key string, field string) (bool, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return n.cache.HashExists(ctx, prefix+key, field)
+}
+
+// HashScanStruct reads the hash into the struct pointed by dest
+func (n *namespace) HashScanStruct(ctx context.Context, key string, dest interface{}) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	return n.cache.HashScanStruct(ctx, prefix+key, dest)
+}
+
+// HashExpire sets time to expire the hash fields
+func (n *namespace) HashExpire(ctx context.Context, key string, expiration time.Duration, fields ...string) ([]bool, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.cache.HashExpire(ctx, prefix+key, expiration, fields...)
+}
+
+// HashTTL returns remaining time to live of the hash fields
+func (n *namespace) HashTTL(ctx context.Context, key string, fields ...string) ([]time.Duration, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.cache.HashTTL(ctx, prefix+key, fields...)
+}
+
+// Expire sets time to expire key into cache
+func (n *namespace) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	return n.cache.Expire(ctx, prefix+key, expiration)
+}
+
+// PExpire sets time to expire key into cache, reports whether the key exists
+func (n *namespace) PExpire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return n.cache.PExpire(ctx, prefix+key, expiration)
+}
+
+// TTL returns remaining time to live of the key
+func (n *namespace) TTL(ctx context.Context, key string) (time.Duration, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return n.cache.TTL(ctx, prefix+key)
+}
+
+// Persist removes expiration of the key
+func (n *namespace) Persist(ctx context.Context, key string) (bool, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return n.cache.Persist(ctx, prefix+key)
+}
+
+// Delete a value by its key into cache
+func (n *namespace) Delete(ctx context.Context, key string) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	return n.cache.Delete(ctx, prefix+key)
+}
+
+// Ping tests cache connection
+func (n *namespace) Ping(ctx context.Context) error {
+	return n.cache.Ping(ctx)
+}
+
+// Close closes cache connection
+func (n *namespace) Close() error {
+	return n.cache.Close()
+}
+
+func (n *namespace) prefix(ctx context.Context) (string, error) {
+	generation, err := n.Generation(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get namespace generation")
+	}
+
+	return n.base + "g" + strconv.FormatInt(generation, 10) + ":", nil
+}
+
+func (n *namespace) generationKey() string {
+	return n.base + "generation"
+}
+
+func (n *namespace) loadGeneration(ctx context.Context) (int64, error) {
+	value, err := n.cache.HashGet(ctx, n.generationKey(), generationField)
+	if errors.Is(err, cache.ErrNotFound) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch v := value.(type) {
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, errors.Errorf("unexpected type %T of namespace generation", value)
+	}
+}
+
+func prefixed(prefix string, keys []string) []string {
+	result := make([]string, len(keys))
+	for i, key := range keys {
+		result[i] = prefix + key
+	}
+
+	return result
+}
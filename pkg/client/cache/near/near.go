@@ -0,0 +1,382 @@
+package near
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	"github.com/andredubov/golibs/pkg/client/cache/memory"
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// Bus delivers key invalidations between instances sharing the remote cache
+type Bus interface {
+	// Publish notifies all instances that the key has been changed
+	Publish(ctx context.Context, key string) error
+	// Subscribe starts delivering invalidations until Close, reset is called whenever
+	// invalidations may have been lost (e.g. after reconnect)
+	Subscribe(ctx context.Context, invalidate func(key string), reset func()) error
+	Close() error
+}
+
+const (
+	// versionShards is the number of invalidation versions, keys are spread over them by hash
+	versionShards = 256
+	// publishTimeout bounds the invalidation publish, it isn't canceled with the caller's context
+	// since the remote write is already done and other instances must learn about it
+	publishTimeout = 5 * time.Second
+)
+
+type nearCache struct {
+	local atomic.Value
+	// versions are bumped by invalidations of keys of their shard, a value read from remote
+	// isn't kept locally if the version of its key has changed meanwhile
+	versions [versionShards]atomic.Uint64
+	remote   cache.Cache
+	bus      Bus
+	localTTL time.Duration
+	size     int
+}
+
+// New returns a cache keeping up to size recently read keys of remote locally for localTTL,
+// local copies are invalidated across instances through bus
+func New(ctx context.Context, remote cache.Cache, bus Bus, size int, localTTL time.Duration) (cache.Cache, error) {
+	n := &nearCache{
+		remote:   remote,
+		bus:      bus,
+		localTTL: localTTL,
+		size:     size,
+	}
+	n.local.Store(memory.NewCache(size, localTTL))
+
+	if err := bus.Subscribe(ctx, n.invalidateLocal, n.resetLocal); err != nil {
+		n.localCache().Close()
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// Set binds key and its value
+func (n *nearCache) Set(ctx context.Context, key string, value interface{}) error {
+	defer n.invalidate(ctx, key)
+
+	return n.remote.Set(ctx, key, value)
+}
+
+// SetWithOptions binds key and its value applying expiration and write condition
+func (n *nearCache) SetWithOptions(ctx context.Context, key string, value interface{}, opts cache.SetOptions) (bool, interface{}, error) {
+	defer n.invalidate(ctx, key)
+
+	return n.remote.SetWithOptions(ctx, key, value, opts)
+}
+
+// Get returns value by its key from local copy or from remote cache
+func (n *nearCache) Get(ctx context.Context, key string) (interface{}, error) {
+	if value, err := n.localCache().Get(ctx, key); err == nil && value != nil {
+		return value, nil
+	}
+
+	version := n.version(key).Load()
+
+	value, err := n.remote.Get(ctx, key)
+	if err != nil || value == nil {
+		return value, err
+	}
+
+	n.storeLocal(ctx, key, version, func(local cache.Cache) error {
+		_, _, err := local.SetWithOptions(ctx, key, value, cache.SetOptions{TTL: n.localTTL})
+		return err
+	})
+
+	return value, nil
+}
+
+// MSet binds keys and their values
+func (n *nearCache) MSet(ctx context.Context, values map[string]interface{}) error {
+	defer func() {
+		for key := range values {
+			n.invalidate(ctx, key)
+		}
+	}()
+
+	return n.remote.MSet(ctx, values)
+}
+
+// MGet returns values by their keys from local copies, missing ones are read from remote cache at once
+func (n *nearCache) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	values, err := n.localCache().MGet(ctx, keys...)
+	if err != nil {
+		values = make([]interface{}, len(keys))
+	}
+
+	var missing []string
+	var positions []int
+	for i, value := range values {
+		if value == nil {
+			missing = append(missing, keys[i])
+			positions = append(positions, i)
+		}
+	}
+
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	versions := make([]uint64, len(missing))
+	for i, key := range missing {
+		versions[i] = n.version(key).Load()
+	}
+
+	remote, err := n.remote.MGet(ctx, missing...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range remote {
+		values[positions[i]] = value
+		if value == nil {
+			continue
+		}
+
+		n.storeLocal(ctx, missing[i], versions[i], func(local cache.Cache) error {
+			_, _, err := local.SetWithOptions(ctx, missing[i], value, cache.SetOptions{TTL: n.localTTL})
+			return err
+		})
+	}
+
+	return values, nil
+}
+
+// MDelete deletes values by their keys into cache
+func (n *nearCache) MDelete(ctx context.Context, keys ...string) error {
+	defer func() {
+		for _, key := range keys {
+			n.invalidate(ctx, key)
+		}
+	}()
+
+	return n.remote.MDelete(ctx, keys...)
+}
+
+// HashSet binds key and value pair to the hash into cache
+func (n *nearCache) HashSet(ctx context.Context, key string, values interface{}) error {
+	defer n.invalidate(ctx, key)
+
+	return n.remote.HashSet(ctx, key, values)
+}
+
+// HashGetAll returns a sequence of key-value pairs of the corresponding hash from local copy or from remote cache
+func (n *nearCache) HashGetAll(ctx context.Context, key string) ([]interface{}, error) {
+	if values, err := n.localCache().HashGetAll(ctx, key); err == nil && len(values) > 0 {
+		return values, nil
+	}
+
+	version := n.version(key).Load()
+
+	values, err := n.remote.HashGetAll(ctx, key)
+	if err != nil || len(values) == 0 {
+		return values, err
+	}
+
+	n.storeLocal(ctx, key, version, func(local cache.Cache) error {
+		if err := local.HashSet(ctx, key, values); err != nil {
+			return err
+		}
+
+		return local.Expire(ctx, key, n.localTTL)
+	})
+
+	return values, nil
+}
+
+// HashGet returns the value of the hash field from local copy of the hash or from remote cache
+func (n *nearCache) HashGet(ctx context.Context, key string, field string) (interface{}, error) {
+	if value, err := n.localCache().HashGet(ctx, key, field); err == nil && value != nil {
+		return value, nil
+	}
+
+	return n.remote.HashGet(ctx, key, field)
+}
+
+// HashMGet returns values of the hash fields from local copy of the hash or from remote cache
+func (n *nearCache) HashMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error) {
+	if values, err := n.localCache().HashMGet(ctx, key, fields...); err == nil && !hasNil(values) {
+		return values, nil
+	}
+
+	return n.remote.HashMGet(ctx, key, fields...)
+}
+
+// HashDel deletes the hash fields, returns the number of deleted fields
+func (n *nearCache) HashDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	defer n.invalidate(ctx, key)
+
+	return n.remote.HashDel(ctx, key, fields...)
+}
+
+// HashIncrBy increments the integer value of the hash field, returns the value after the increment
+func (n *nearCache) HashIncrBy(ctx context.Context, key string, field string, increment int64) (int64, error) {
+	defer n.invalidate(ctx, key)
+
+	return n.remote.HashIncrBy(ctx, key, field, increment)
+}
+
+// HashExists reports whether the hash field exists in local copy of the hash or in remote cache
+func (n *nearCache) HashExists(ctx context.Context, key string, field string) (bool, error) {
+	if ok, err := n.localCache().HashExists(ctx, key, field); err == nil && ok {
+		return true, nil
+	}
+
+	return n.remote.HashExists(ctx, key, field)
+}
+
+// HashScanStruct reads the hash from local copy or from remote cache into the struct pointed by dest
+func (n *nearCache) HashScanStruct(ctx context.Context, key string, dest interface{}) error {
+	values, err := n.HashGetAll(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return redigo.ScanStruct(values, dest)
+}
+
+// HashExpire sets time to expire the hash fields, local copy of the hash is dropped
+func (n *nearCache) HashExpire(ctx context.Context, key string, expiration time.Duration, fields ...string) ([]bool, error) {
+	defer n.invalidate(ctx, key)
+
+	return n.remote.HashExpire(ctx, key, expiration, fields...)
+}
+
+// HashTTL returns remaining time to live of the hash fields in remote cache
+func (n *nearCache) HashTTL(ctx context.Context, key string, fields ...string) ([]time.Duration, error) {
+	return n.remote.HashTTL(ctx, key, fields...)
+}
+
+// Expire sets time to expire key into cache
+func (n *nearCache) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	defer n.invalidate(ctx, key)
+
+	return n.remote.Expire(ctx, key, expiration)
+}
+
+// PExpire sets time to expire key into cache, reports whether the key exists
+func (n *nearCache) PExpire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	defer n.invalidate(ctx, key)
+
+	return n.remote.PExpire(ctx, key, expiration)
+}
+
+// TTL returns remaining time to live of the key in remote cache
+func (n *nearCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return n.remote.TTL(ctx, key)
+}
+
+// Persist removes expiration of the key, reports whether the expiration was removed
+func (n *nearCache) Persist(ctx context.Context, key string) (bool, error) {
+	defer n.invalidate(ctx, key)
+
+	return n.remote.Persist(ctx, key)
+}
+
+// Delete a value by its key into cache
+func (n *nearCache) Delete(ctx context.Context, key string) error {
+	defer n.invalidate(ctx, key)
+
+	return n.remote.Delete(ctx, key)
+}
+
+// Ping tests remote cache connection
+func (n *nearCache) Ping(ctx context.Context) error {
+	return n.remote.Ping(ctx)
+}
+
+// Close stops invalidations and closes both local and remote caches
+func (n *nearCache) Close() error {
+	if err := n.bus.Close(); err != nil {
+		log.Printf("failed to close near cache bus: %v\n", err)
+	}
+
+	if err := n.localCache().Close(); err != nil {
+		log.Printf("failed to close near cache: %v\n", err)
+	}
+
+	return n.remote.Close()
+}
+
+// invalidate drops the local copy and notifies other instances, it runs after the remote write
+func (n *nearCache) invalidate(ctx context.Context, key string) {
+	n.invalidateLocal(key)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), publishTimeout)
+	defer cancel()
+
+	if err := n.bus.Publish(ctx, key); err != nil {
+		log.Printf("failed to publish near cache invalidation of key %q: %v\n", key, err)
+	}
+}
+
+func (n *nearCache) invalidateLocal(key string) {
+	// the version is bumped before the delete, so a concurrent storeLocal either sees it or is deleted
+	n.version(key).Add(1)
+
+	err := n.localCache().Delete(context.Background(), key)
+	if err != nil && !errors.Is(err, memory.ErrClosed) {
+		log.Printf("failed to invalidate near cache key %q: %v\n", key, err)
+	}
+}
+
+// resetLocal drops all local copies, they may be stale if invalidations were lost
+func (n *nearCache) resetLocal() {
+	for i := range n.versions {
+		n.versions[i].Add(1)
+	}
+
+	stale := n.local.Swap(memory.NewCache(n.size, n.localTTL)).(cache.Cache)
+	stale.Close()
+}
+
+// storeLocal keeps a value read from remote since version unless the key has been invalidated meanwhile,
+// the version is checked again after the store to drop the copy if an invalidation raced with it
+func (n *nearCache) storeLocal(ctx context.Context, key string, version uint64, store func(local cache.Cache) error) {
+	current := n.version(key)
+	if current.Load() != version {
+		return
+	}
+
+	local := n.localCache()
+	err := store(local)
+	if err == nil && current.Load() != version {
+		err = local.Delete(ctx, key)
+	}
+
+	if err != nil && !errors.Is(err, memory.ErrClosed) {
+		log.Printf("failed to store near cache key %q: %v\n", key, err)
+	}
+}
+
+// version returns the invalidation version of the key's shard
+func (n *nearCache) version(key string) *atomic.Uint64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return &n.versions[h.Sum32()%versionShards]
+}
+
+func (n *nearCache) localCache() cache.Cache {
+	return n.local.Load().(cache.Cache)
+}
+
+func hasNil(values []interface{}) bool {
+	for _, value := range values {
+		if value == nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,38 +2,220 @@ package redis
 
 import (
 	"context"
+	"log"
+	"time"
 
 	"github.com/andredubov/golibs/pkg/client/cache"
 	"github.com/andredubov/golibs/pkg/config"
 	redigo "github.com/gomodule/redigo/redis"
 )
 
+// Client interface for working with redis specific capabilities besides cache
+type Client interface {
+	cache.Client
+	PubSub() PubSub
+	Pipeline() Pipeline
+	TxManager(maxRetries int) TxManager
+	RunScript(ctx context.Context, script *Script, keysAndArgs ...interface{}) *Result
+	LoadScripts(ctx context.Context) error
+	Scanner() Scanner
+	// Pool returns the pool of connections to the master, it follows failovers if sentinels are configured
+	Pool() *redigo.Pool
+}
+
 type redisClient struct {
-	masterCache cache.Cache
+	connectionPool *redigo.Pool
+	replicaPool    *redigo.Pool
+	masterCache    *rd
+	pubSub         PubSub
+	sentinel       *sentinel
 }
 
-// New returns a new instance of redisClient struct
-func New(ctx context.Context, cfg config.RedisConfig) (cache.Client, error) {
-	connectionPool := &redigo.Pool{
-		MaxIdle:     cfg.MaxIdle(),
-		IdleTimeout: cfg.IdleTimeout(),
-		DialContext: func(ctx context.Context) (redigo.Conn, error) {
-			return redigo.DialContext(ctx, "tcp", cfg.Address())
-		},
+// New returns a new instance of redisClient struct, if sentinels are configured
+// the master is discovered through them and followed on failover
+func New(ctx context.Context, cfg config.RedisConfig, opts ...Option) (Client, error) {
+	options := newOptions(opts)
+
+	if len(cfg.SentinelAddresses()) != 0 {
+		return newSentinelClient(cfg, options), nil
 	}
 
+	connectionPool := NewPool(cfg)
+	masterCache := &rd{connectionPool: connectionPool, config: cfg, metrics: options.metrics}
+	masterCache.collectPoolStats()
+
 	return &redisClient{
-		masterCache: &rd{connectionPool, cfg},
+		connectionPool: connectionPool,
+		masterCache:    masterCache,
+		pubSub:         NewPubSub(connectionPool),
 	}, nil
 }
 
+func newSentinelClient(cfg config.RedisConfig, options options) *redisClient {
+	sentinel := newSentinel(cfg)
+	connectionPool := sentinel.masterPool()
+
+	var replicaPool *redigo.Pool
+	if cfg.ReadFromReplicas() {
+		replicaPool = sentinel.replicaPool()
+	}
+
+	sentinel.watch()
+
+	masterCache := &rd{connectionPool: connectionPool, replicaPool: replicaPool, config: cfg, metrics: options.metrics}
+	masterCache.collectPoolStats()
+
+	return &redisClient{
+		connectionPool: connectionPool,
+		replicaPool:    replicaPool,
+		masterCache:    masterCache,
+		pubSub:         NewPubSub(connectionPool),
+		sentinel:       sentinel,
+	}
+}
+
+// NewPool returns a new redis connection pool to cfg.Address(), with sentinels use Client.Pool() instead
+func NewPool(cfg config.RedisConfig) *redigo.Pool {
+	pool := &redigo.Pool{
+		MaxIdle:         cfg.MaxIdle(),
+		MaxActive:       cfg.MaxActive(),
+		Wait:            cfg.Wait(),
+		IdleTimeout:     cfg.IdleTimeout(),
+		MaxConnLifetime: cfg.MaxConnLifetime(),
+		DialContext: func(ctx context.Context) (redigo.Conn, error) {
+			return dial(ctx, cfg, cfg.Address())
+		},
+	}
+
+	// connections idle for longer than the interval are pinged before use
+	if interval := cfg.TestOnBorrowInterval(); interval > 0 {
+		pool.TestOnBorrowContext = func(ctx context.Context, c redigo.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < interval {
+				return nil
+			}
+
+			_, err := redigo.DoContext(c, ctx, "PING")
+
+			return err
+		}
+	}
+
+	return pool
+}
+
+func dial(ctx context.Context, cfg config.RedisConfig, address string) (redigo.Conn, error) {
+	options := append(dialOptions(cfg), redigo.DialReadTimeout(cfg.ReadTimeout()))
+
+	// SELECT isn't supported by redis cluster
+	if len(cfg.ClusterAddresses()) == 0 {
+		options = append(options, redigo.DialDatabase(cfg.DB()))
+	}
+
+	return redigo.DialContext(ctx, "tcp", address, options...)
+}
+
+// sentinelDialOptions returns sentinel credentials, TLS and timeouts of connections to sentinels except the read timeout
+func sentinelDialOptions(cfg config.RedisConfig) []redigo.DialOption {
+	options := []redigo.DialOption{
+		redigo.DialWriteTimeout(cfg.WriteTimeout()),
+		redigo.DialUsername(cfg.SentinelUsername()),
+		redigo.DialPassword(cfg.SentinelPassword()),
+	}
+
+	if cfg.DialTimeout() > 0 {
+		options = append(options, redigo.DialConnectTimeout(cfg.DialTimeout()))
+	}
+
+	if tlsConfig := cfg.SentinelTLSConfig(); tlsConfig != nil {
+		options = append(options, redigo.DialUseTLS(true), redigo.DialTLSConfig(tlsConfig))
+	}
+
+	return options
+}
+
+// dialOptions returns credentials, TLS and timeouts of connections to redis except the read timeout
+func dialOptions(cfg config.RedisConfig) []redigo.DialOption {
+	options := []redigo.DialOption{
+		redigo.DialWriteTimeout(cfg.WriteTimeout()),
+		redigo.DialUsername(cfg.Username()),
+		redigo.DialPassword(cfg.Password()),
+	}
+
+	// zero timeout would disable the default one of the dialer
+	if cfg.DialTimeout() > 0 {
+		options = append(options, redigo.DialConnectTimeout(cfg.DialTimeout()))
+	}
+
+	if tlsConfig := cfg.TLSConfig(); tlsConfig != nil {
+		options = append(options, redigo.DialUseTLS(true), redigo.DialTLSConfig(tlsConfig))
+	}
+
+	return options
+}
+
 // Cache returns cache
 func (r *redisClient) Cache() cache.Cache {
 	return r.masterCache
 }
 
-// Close cache connection
+// Stats returns statistics of the connection pools to the master and replicas
+func (r *redisClient) Stats() cache.PoolStats {
+	return r.masterCache.poolStats()
+}
+
+// PubSub returns publish/subscribe client
+func (r *redisClient) PubSub() PubSub {
+	return r.pubSub
+}
+
+// Pipeline returns a new empty pipeline
+func (r *redisClient) Pipeline() Pipeline {
+	return NewPipeline(r.connectionPool, r.masterCache.config.CommandTimeout())
+}
+
+// TxManager returns optimistic transaction manager retrying aborted transactions up to maxRetries times
+func (r *redisClient) TxManager(maxRetries int) TxManager {
+	return NewTxManager(r.connectionPool, maxRetries, r.masterCache.config.CommandTimeout())
+}
+
+// RunScript runs the script on the master like any other cache command
+func (r *redisClient) RunScript(ctx context.Context, script *Script, keysAndArgs ...interface{}) *Result {
+	return r.masterCache.runScript(ctx, script, keysAndArgs...)
+}
+
+// LoadScripts preloads all registered scripts, it's meant to be called at startup
+func (r *redisClient) LoadScripts(ctx context.Context) error {
+	conn, err := r.connectionPool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return LoadScripts(ctx, conn)
+}
+
+// Scanner returns iterator factory over SCAN family commands
+func (r *redisClient) Scanner() Scanner {
+	return NewScanner(r.connectionPool)
+}
+
+// Pool returns the pool of connections to the master
+func (r *redisClient) Pool() *redigo.Pool {
+	return r.connectionPool
+}
+
+// Close ends subscriptions, sentinel watching and cache connection
 func (r *redisClient) Close() error {
+	if r.sentinel != nil {
+		r.sentinel.close()
+	}
+
+	if r.pubSub != nil {
+		if err := r.pubSub.Close(); err != nil {
+			log.Printf("failed to close redis pubsub: %v\n", err)
+		}
+	}
+
 	if r.masterCache != nil {
 		return r.masterCache.Close()
 	}
@@ -0,0 +1,570 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	"github.com/andredubov/golibs/pkg/config"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxRedirects            = 5
+	clusterRetryInterval    = 100 * time.Millisecond
+	minSlotsRefreshInterval = time.Second
+)
+
+// ErrNoClusterNode is returned when none of the cluster nodes is reachable
+var ErrNoClusterNode = errors.New("no redis cluster node is available")
+
+type clusterClient struct {
+	masterCache cache.Cache
+	cluster     *cluster
+}
+
+// NewCluster returns a new instance of redis cluster client, the nodes are discovered from
+// cfg.ClusterAddresses() (or cfg.Address() if not specified) and followed on resharding and failover
+func NewCluster(ctx context.Context, cfg config.RedisConfig, opts ...Option) (cache.Client, error) {
+	seeds := cfg.ClusterAddresses()
+	if len(seeds) == 0 {
+		seeds = []string{cfg.Address()}
+	}
+
+	c := &cluster{
+		cfg:   cfg,
+		seeds: seeds,
+		pools: make(map[string]*redigo.Pool),
+	}
+
+	if err := c.refresh(ctx); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	masterCache := &rd{cluster: c, config: cfg, metrics: newOptions(opts).metrics}
+	masterCache.collectPoolStats()
+
+	return &clusterClient{
+		masterCache: &clusterCache{rd: masterCache, cluster: c},
+		cluster:     c,
+	}, nil
+}
+
+// Cache returns cache
+func (c *clusterClient) Cache() cache.Cache {
+	return c.masterCache
+}
+
+// Stats returns statistics of the connection pools to all nodes
+func (c *clusterClient) Stats() cache.PoolStats {
+	var stats cache.PoolStats
+	for _, pool := range c.cluster.allPools() {
+		stats = addPoolStats(stats, poolStats(pool))
+	}
+
+	return stats
+}
+
+// Close cache connections to all nodes
+func (c *clusterClient) Close() error {
+	if c.masterCache != nil {
+		return c.masterCache.Close()
+	}
+
+	return nil
+}
+
+// clusterCache splits multi-key commands by slots, single-key commands are routed by rd
+type clusterCache struct {
+	*rd
+	cluster *cluster
+}
+
+// MSet binds keys and their values, keys of different slots are set separately
+func (c *clusterCache) MSet(ctx context.Context, values map[string]interface{}) error {
+	groups := make(map[int]map[string]interface{})
+	for key, value := range values {
+		slot := hashSlot(key)
+		if groups[slot] == nil {
+			groups[slot] = make(map[string]interface{})
+		}
+		groups[slot][key] = value
+	}
+
+	for _, group := range groups {
+		if err := c.rd.MSet(ctx, group); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MGet returns values by their keys from cache, keys of different slots are read separately
+func (c *clusterCache) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	values := make([]interface{}, len(keys))
+	for slot, positions := range groupBySlot(keys) {
+		group := make([]string, len(positions))
+		for i, position := range positions {
+			group[i] = keys[position]
+		}
+
+		groupValues, err := c.rd.MGet(ctx, group...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get keys of slot %d", slot)
+		}
+
+		for i, position := range positions {
+			values[position] = groupValues[i]
+		}
+	}
+
+	return values, nil
+}
+
+// MDelete deletes values by their keys into cache, keys of different slots are deleted separately
+func (c *clusterCache) MDelete(ctx context.Context, keys ...string) error {
+	for _, positions := range groupBySlot(keys) {
+		group := make([]string, len(positions))
+		for i, position := range positions {
+			group[i] = keys[position]
+		}
+
+		if err := c.rd.MDelete(ctx, group...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ping tests connections to all master nodes
+func (c *clusterCache) Ping(ctx context.Context) error {
+	ctx, cancel := c.commandContext(ctx)
+	defer cancel()
+
+	for _, address := range c.cluster.masters() {
+		err := c.cluster.executeOn(ctx, address, false, c.instrument(func(ctx context.Context, conn redigo.Conn) error {
+			_, err := redigo.DoContext(conn, ctx, "PING")
+			return err
+		}))
+		if err != nil {
+			return errors.Wrapf(contextError(ctx, err), "failed to ping %s", address)
+		}
+	}
+
+	return nil
+}
+
+// groupBySlot returns positions of the keys grouped by their slots
+func groupBySlot(keys []string) map[int][]int {
+	groups := make(map[int][]int)
+	for i, key := range keys {
+		slot := hashSlot(key)
+		groups[slot] = append(groups[slot], i)
+	}
+
+	return groups
+}
+
+// cluster keeps the slot map and a connection pool per node
+type cluster struct {
+	cfg         config.RedisConfig
+	seeds       []string
+	mu          sync.RWMutex
+	slots       [clusterSlots]string
+	pools       map[string]*redigo.Pool
+	closed      bool
+	refreshMu   sync.Mutex
+	refreshedAt time.Time
+}
+
+// route runs handler on the node serving the key following MOVED/ASK redirects,
+// keyless commands aren't bound to a slot and run on any master
+func (c *cluster) route(ctx context.Context, key string, handler handler) error {
+	if key == "" {
+		return c.executeAny(ctx, handler)
+	}
+
+	slot := hashSlot(key)
+	address := c.slotAddress(slot)
+	asking := false
+
+	var err error
+	for attempt := 0; attempt <= maxRedirects; attempt++ {
+		if address == "" {
+			if err = c.refresh(ctx); err != nil {
+				return err
+			}
+
+			if address = c.slotAddress(slot); address == "" {
+				return errors.Errorf("slot %d is not served by any node", slot)
+			}
+		}
+
+		err = c.executeOn(ctx, address, asking, handler)
+		if err == nil {
+			return nil
+		}
+
+		kind, movedSlot, target, ok := parseRedirect(err)
+		switch {
+		case ok && kind == "MOVED":
+			// the slot has been migrated for good, the whole map is likely outdated
+			c.setSlotAddress(movedSlot, target)
+			go c.refreshLater()
+			address, asking = target, false
+		case ok && kind == "ASK":
+			// the slot is being migrated, only this command is redirected
+			address, asking = target, true
+		case ctx.Err() != nil:
+			// the deadline hit the command, the node is not necessarily failed
+			return err
+		case isNodeFailure(err):
+			log.Printf("redis cluster node %s failed: %v\n", address, err)
+			address, asking = "", false
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(clusterRetryInterval):
+			}
+		default:
+			return err
+		}
+	}
+
+	return errors.Wrapf(err, "too many redirects for key %q", key)
+}
+
+// executeAny runs keyless handler on the first reachable master, another node is tried only if the command wasn't applied
+func (c *cluster) executeAny(ctx context.Context, handler handler) error {
+	masters := c.masters()
+	if len(masters) == 0 {
+		if err := c.refresh(ctx); err != nil {
+			return err
+		}
+		masters = c.masters()
+	}
+
+	err := ErrNoClusterNode
+	for _, address := range masters {
+		if err = c.executeOn(ctx, address, false, handler); err == nil || ctx.Err() != nil || !isNodeFailure(err) {
+			return err
+		}
+
+		log.Printf("redis cluster node %s failed: %v\n", address, err)
+	}
+
+	return err
+}
+
+func (c *cluster) executeOn(ctx context.Context, address string, asking bool, handler handler) error {
+	pool, err := c.pool(address)
+	if err != nil {
+		return err
+	}
+
+	getConnTimeoutCtx, cancel := context.WithTimeout(ctx, c.cfg.ConnectionTimeout())
+	defer cancel()
+
+	conn, err := pool.GetContext(getConnTimeoutCtx)
+	if err != nil {
+		return &unsentError{err: err}
+	}
+
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("failed to close redis connection: %v\n", err)
+		}
+	}()
+
+	if asking {
+		if _, err = redigo.DoContext(conn, ctx, "ASKING"); err != nil {
+			return &unsentError{err: err}
+		}
+	}
+
+	return handler(ctx, conn)
+}
+
+func (c *cluster) slotAddress(slot int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.slots[slot]
+}
+
+func (c *cluster) setSlotAddress(slot int, address string) {
+	c.mu.Lock()
+	c.slots[slot] = address
+	c.mu.Unlock()
+}
+
+// masters returns addresses of the nodes serving slots
+func (c *cluster) masters() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := make(map[string]struct{})
+	var addresses []string
+	for _, address := range c.slots {
+		if _, ok := seen[address]; ok || address == "" {
+			continue
+		}
+		seen[address] = struct{}{}
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+func (c *cluster) allPools() []*redigo.Pool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	pools := make([]*redigo.Pool, 0, len(c.pools))
+	for _, pool := range c.pools {
+		pools = append(pools, pool)
+	}
+
+	return pools
+}
+
+func (c *cluster) pool(address string) (*redigo.Pool, error) {
+	c.mu.RLock()
+	pool, ok := c.pools[address]
+	closed := c.closed
+	c.mu.RUnlock()
+
+	if ok {
+		return pool, nil
+	}
+
+	if closed {
+		return nil, errors.New("redis cluster client is closed")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if pool, ok = c.pools[address]; !ok {
+		pool = NewPool(c.cfg)
+		pool.DialContext = func(ctx context.Context) (redigo.Conn, error) {
+			return dial(ctx, c.cfg, address)
+		}
+		c.pools[address] = pool
+	}
+
+	return pool, nil
+}
+
+func (c *cluster) refreshLater() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.ConnectionTimeout())
+	defer cancel()
+
+	if err := c.refresh(ctx); err != nil {
+		log.Printf("failed to refresh redis cluster slots: %v\n", err)
+	}
+}
+
+// refresh reloads the slot map from CLUSTER SLOTS of any reachable node, it's throttled
+// so that a burst of redirects causes a single reload
+func (c *cluster) refresh(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	if time.Since(c.refreshedAt) < minSlotsRefreshInterval {
+		return nil
+	}
+
+	lastErr := ErrNoClusterNode
+	for _, address := range c.candidates() {
+		var slots [clusterSlots]string
+		err := c.executeOn(ctx, address, false, func(ctx context.Context, conn redigo.Conn) error {
+			var errEx error
+			slots, errEx = loadSlots(ctx, conn, address)
+			return errEx
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+		c.refreshedAt = time.Now()
+
+		c.dropUnusedPools()
+
+		return nil
+	}
+
+	return errors.Wrap(lastErr, "failed to load redis cluster slots")
+}
+
+// candidates returns known nodes followed by seeds
+func (c *cluster) candidates() []string {
+	addresses := c.masters()
+	seen := make(map[string]struct{}, len(addresses))
+	for _, address := range addresses {
+		seen[address] = struct{}{}
+	}
+
+	for _, address := range c.seeds {
+		if _, ok := seen[address]; !ok {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
+// dropUnusedPools closes pools of the nodes that no longer serve slots
+func (c *cluster) dropUnusedPools() {
+	used := make(map[string]struct{})
+	for _, address := range c.masters() {
+		used[address] = struct{}{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for address, pool := range c.pools {
+		if _, ok := used[address]; ok {
+			continue
+		}
+
+		delete(c.pools, address)
+		if err := pool.Close(); err != nil {
+			log.Printf("failed to close redis pool of %s: %v\n", address, err)
+		}
+	}
+}
+
+func (c *cluster) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+
+	var lastErr error
+	for address, pool := range c.pools {
+		if err := pool.Close(); err != nil {
+			lastErr = err
+		}
+		delete(c.pools, address)
+	}
+
+	return lastErr
+}
+
+// loadSlots parses CLUSTER SLOTS reply [[start, end, [ip, port, id], replicas...], ...]
+func loadSlots(ctx context.Context, conn redigo.Conn, queried string) ([clusterSlots]string, error) {
+	var slots [clusterSlots]string
+
+	ranges, err := redigo.Values(redigo.DoContext(conn, ctx, "CLUSTER", "SLOTS"))
+	if err != nil {
+		return slots, err
+	}
+
+	for _, r := range ranges {
+		fields, err := redigo.Values(r, nil)
+		if err != nil || len(fields) < 3 {
+			return slots, errors.Errorf("unexpected CLUSTER SLOTS reply: %v", err)
+		}
+
+		start, err := redigo.Int(fields[0], nil)
+		if err != nil {
+			return slots, err
+		}
+
+		end, err := redigo.Int(fields[1], nil)
+		if err != nil {
+			return slots, err
+		}
+
+		master, err := redigo.Values(fields[2], nil)
+		if err != nil || len(master) < 2 {
+			return slots, errors.Errorf("unexpected CLUSTER SLOTS node: %v", err)
+		}
+
+		host, err := redigo.String(master[0], nil)
+		if err != nil {
+			return slots, err
+		}
+
+		port, err := redigo.Int(master[1], nil)
+		if err != nil {
+			return slots, err
+		}
+
+		// an empty host means the node we have queried
+		if host == "" {
+			if host, _, err = net.SplitHostPort(queried); err != nil {
+				return slots, err
+			}
+		}
+
+		address := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end && slot < clusterSlots; slot++ {
+			slots[slot] = address
+		}
+	}
+
+	return slots, nil
+}
+
+// parseRedirect parses "MOVED <slot> <address>" and "ASK <slot> <address>" errors
+func parseRedirect(err error) (kind string, slot int, address string, ok bool) {
+	var redisErr redigo.Error
+	if !errors.As(err, &redisErr) {
+		return "", 0, "", false
+	}
+
+	parts := strings.Fields(string(redisErr))
+	if len(parts) != 3 || (parts[0] != "MOVED" && parts[0] != "ASK") {
+		return "", 0, "", false
+	}
+
+	slot, err = strconv.Atoi(parts[1])
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return "", 0, "", false
+	}
+
+	return parts[0], slot, parts[2], true
+}
+
+// unsentError is a failure to reach the node before the command is written, so retrying it can't apply the command twice
+type unsentError struct {
+	err error
+}
+
+func (e *unsentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unsentError) Unwrap() error {
+	return e.err
+}
+
+// isNodeFailure reports whether the command wasn't applied because the node is unreachable or a failover is in progress,
+// a connection failure after the command has been written isn't retried since the command might have been applied
+func isNodeFailure(err error) bool {
+	var redisErr redigo.Error
+	if errors.As(err, &redisErr) {
+		message := string(redisErr)
+		return strings.HasPrefix(message, "CLUSTERDOWN") || strings.HasPrefix(message, "TRYAGAIN")
+	}
+
+	var unsent *unsentError
+	return errors.As(err, &unsent)
+}
@@ -0,0 +1,43 @@
+package redis
+
+import "strings"
+
+const clusterSlots = 16384
+
+// crc16Table is a lookup table of CRC16/XMODEM used by redis cluster
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+
+	return table
+}()
+
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^key[i]]
+	}
+
+	return crc
+}
+
+// hashSlot returns cluster slot of the key, only the {hash tag} is hashed if the key has a non-empty one
+func hashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key)) % clusterSlots
+}
@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// contextError maps the error caused by cancellation or expired deadline of ctx to
+// cache.ErrCanceled or cache.ErrTimeout, the context error is kept in the chain
+func contextError(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, cache.ErrCanceled) || errors.Is(err, cache.ErrTimeout) {
+		return err
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		sentinel := cache.ErrTimeout
+		if errors.Is(ctxErr, context.Canceled) {
+			sentinel = cache.ErrCanceled
+		}
+
+		// the deadline may be reported by the socket as i/o timeout
+		if !errors.Is(err, ctxErr) {
+			return fmt.Errorf("%w: %w: %w", sentinel, ctxErr, err)
+		}
+
+		return fmt.Errorf("%w: %w", sentinel, err)
+	}
+
+	// the connection wait and the socket read are bounded by their own timeouts
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %w", cache.ErrTimeout, err)
+	}
+
+	return err
+}
+
+// replyError makes redis error replies match cache sentinel errors by errors.Is,
+// the redis error is kept in the chain
+func replyError(err error) error {
+	var redisErr redigo.Error
+	if err == nil || !errors.As(err, &redisErr) || errors.Is(err, cache.ErrWrongType) {
+		return err
+	}
+
+	if strings.HasPrefix(string(redisErr), "WRONGTYPE") {
+		return fmt.Errorf("%w: %w", cache.ErrWrongType, err)
+	}
+
+	return err
+}
+
+// fieldTTLError reports ErrHashFieldTTLNotSupported if the server doesn't know hash field expiration commands
+func fieldTTLError(err error) error {
+	var redisErr redigo.Error
+	if errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "ERR unknown command") {
+		return fmt.Errorf("%w: %w", cache.ErrHashFieldTTLNotSupported, err)
+	}
+
+	return err
+}
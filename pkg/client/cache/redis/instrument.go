@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	"github.com/andredubov/golibs/pkg/client/cache/metrics"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// instrumentedConn records latency and errors of every command sent by Do
+type instrumentedConn struct {
+	redigo.Conn
+	metrics metrics.Metrics
+}
+
+// Do sends a command to the server and returns the received reply
+func (c *instrumentedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := c.Conn.Do(cmd, args...)
+	c.observe(cmd, start, err)
+
+	return reply, err
+}
+
+// DoContext sends a command to the server and returns the received reply
+func (c *instrumentedConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := redigo.DoContext(c.Conn, ctx, cmd, args...)
+	c.observe(cmd, start, err)
+
+	return reply, err
+}
+
+// DoWithTimeout sends a command to the server and returns the received reply
+func (c *instrumentedConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := redigo.DoWithTimeout(c.Conn, timeout, cmd, args...)
+	c.observe(cmd, start, err)
+
+	return reply, err
+}
+
+// ReceiveContext receives a single reply from the server
+func (c *instrumentedConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return redigo.ReceiveContext(c.Conn, ctx)
+}
+
+// ReceiveWithTimeout receives a single reply from the server
+func (c *instrumentedConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redigo.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+func (c *instrumentedConn) observe(cmd string, start time.Time, err error) {
+	cmd = strings.ToUpper(cmd)
+	c.metrics.ObserveCommand(cmd, time.Since(start))
+
+	// a missing script is loaded and retried by Script, it isn't a failure of the command
+	if err != nil && !isNoScript(err) {
+		c.metrics.IncError(cmd, errorKind(err))
+	}
+}
+
+// errorKind classifies the error for metrics, redis errors are labeled by their prefix (WRONGTYPE, MOVED, etc.)
+func errorKind(err error) string {
+	var redisErr redigo.Error
+	if errors.As(err, &redisErr) {
+		if kind, _, _ := strings.Cut(string(redisErr), " "); kind != "" {
+			return kind
+		}
+		return "redis"
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, redigo.ErrPoolExhausted):
+		return "pool_exhausted"
+	case errors.As(err, &netErr), errors.Is(err, io.EOF):
+		return "connection"
+	default:
+		return "other"
+	}
+}
+
+func poolStats(pool *redigo.Pool) cache.PoolStats {
+	stats := pool.Stats()
+
+	return cache.PoolStats{
+		ActiveCount:  stats.ActiveCount,
+		IdleCount:    stats.IdleCount,
+		WaitCount:    stats.WaitCount,
+		WaitDuration: stats.WaitDuration,
+	}
+}
+
+func addPoolStats(a, b cache.PoolStats) cache.PoolStats {
+	return cache.PoolStats{
+		ActiveCount:  a.ActiveCount + b.ActiveCount,
+		IdleCount:    a.IdleCount + b.IdleCount,
+		WaitCount:    a.WaitCount + b.WaitCount,
+		WaitDuration: a.WaitDuration + b.WaitDuration,
+	}
+}
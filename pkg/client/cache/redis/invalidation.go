@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/andredubov/golibs/pkg/client/cache/near"
+)
+
+type invalidationBus struct {
+	pubSub       PubSub
+	channel      string
+	subscription Subscription
+	done         chan struct{}
+}
+
+// NewInvalidationBus returns a near cache invalidation bus built on redis pub/sub channel
+func NewInvalidationBus(pubSub PubSub, channel string) near.Bus {
+	return &invalidationBus{
+		pubSub:  pubSub,
+		channel: channel,
+	}
+}
+
+// Publish notifies all instances that the key has been changed
+func (b *invalidationBus) Publish(ctx context.Context, key string) error {
+	_, err := b.pubSub.Publish(ctx, b.channel, key)
+
+	return err
+}
+
+// Subscribe starts delivering invalidations in background until Close
+func (b *invalidationBus) Subscribe(ctx context.Context, invalidate func(key string), reset func()) error {
+	subscription, err := b.pubSub.Subscribe(context.WithoutCancel(ctx), b.channel)
+	if err != nil {
+		return err
+	}
+
+	b.subscription = subscription
+	b.done = make(chan struct{})
+
+	go func() {
+		defer close(b.done)
+
+		for {
+			select {
+			case message, ok := <-subscription.Messages():
+				if !ok {
+					return
+				}
+				invalidate(string(message.Data))
+			case <-subscription.Subscribed():
+				// invalidations published while we were not subscribed are lost
+				reset()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops the subscription
+func (b *invalidationBus) Close() error {
+	if b.subscription == nil {
+		return nil
+	}
+
+	if err := b.subscription.Unsubscribe(); err != nil {
+		return err
+	}
+
+	<-b.done
+
+	return nil
+}
@@ -0,0 +1,302 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	cacheredis "github.com/andredubov/golibs/pkg/client/cache/redis"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTTL           = 10 * time.Second
+	defaultRetryInterval = 100 * time.Millisecond
+	minTTL               = time.Millisecond
+	fencingKeySuffix     = ":fencing"
+)
+
+var (
+	// ErrNotAcquired is returned when the lock is held by another owner
+	ErrNotAcquired = errors.New("lock is held by another owner")
+	// ErrLockLost is returned when the lock has expired or has been taken over
+	ErrLockLost = errors.New("lock is lost")
+)
+
+// acquireScript sets the lock and issues the next fencing token
+var acquireScript = cacheredis.NewScript(2, `
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return false
+`)
+
+// refreshScript prolongs the lock only if it's still owned by the caller
+var refreshScript = cacheredis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lock only if it's still owned by the caller
+var releaseScript = cacheredis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Locker interface for acquiring distributed locks
+type Locker interface {
+	// Acquire waits until the lock is acquired or ctx is done
+	Acquire(ctx context.Context, key string) (Lock, error)
+	// TryAcquire acquires the lock or returns ErrNotAcquired immediately
+	TryAcquire(ctx context.Context, key string) (Lock, error)
+}
+
+// Lock is an acquired distributed lock, with auto refresh enabled its lease is
+// extended until Release is called or ctx passed to Acquire is done
+type Lock interface {
+	Key() string
+	// Token returns the fencing token, it grows with every acquisition of the key
+	// so that downstream storages can reject writes of stale holders
+	Token() int64
+	Refresh(ctx context.Context) error
+	Release(ctx context.Context) error
+	// Lost is closed when auto refresh fails to extend the lease
+	Lost() <-chan struct{}
+}
+
+// Option configures a locker
+type Option func(l *locker)
+
+// WithTTL sets the lease duration of locks, a non-positive ttl is ignored and a shorter than
+// a millisecond one is rounded up to a millisecond since redis expires keys with millisecond precision
+func WithTTL(ttl time.Duration) Option {
+	return func(l *locker) {
+		if ttl <= 0 {
+			return
+		}
+
+		l.ttl = max(ttl, minTTL)
+	}
+}
+
+// WithRetryInterval sets the interval between attempts of Acquire
+func WithRetryInterval(interval time.Duration) Option {
+	return func(l *locker) {
+		l.retryInterval = interval
+	}
+}
+
+// WithAutoRefresh extends the lease of acquired locks every ttl/3 while the holder's context is alive
+func WithAutoRefresh() Option {
+	return func(l *locker) {
+		l.autoRefresh = true
+	}
+}
+
+type locker struct {
+	connectionPool *redigo.Pool
+	ttl            time.Duration
+	retryInterval  time.Duration
+	autoRefresh    bool
+}
+
+// New returns a new instance of redis based locker
+func New(connectionPool *redigo.Pool, opts ...Option) Locker {
+	l := &locker{
+		connectionPool: connectionPool,
+		ttl:            defaultTTL,
+		retryInterval:  defaultRetryInterval,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Acquire waits until the lock is acquired or ctx is done
+func (l *locker) Acquire(ctx context.Context, key string) (Lock, error) {
+	for {
+		lock, err := l.TryAcquire(ctx, key)
+		if !errors.Is(err, ErrNotAcquired) {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(l.retryInterval):
+		}
+	}
+}
+
+// TryAcquire acquires the lock or returns ErrNotAcquired immediately
+func (l *locker) TryAcquire(ctx context.Context, key string) (Lock, error) {
+	owner, err := newOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	var token int64
+	err = l.do(ctx, func(conn redigo.Conn) error {
+		reply, err := acquireScript.Do(ctx, conn, key, fencingKey(key), owner, l.ttl.Milliseconds()).Value()
+		if err != nil {
+			return err
+		}
+
+		if reply == nil {
+			return ErrNotAcquired
+		}
+
+		token, err = redigo.Int64(reply, nil)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lk := &lock{
+		locker: l,
+		key:    key,
+		owner:  owner,
+		token:  token,
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+
+	if l.autoRefresh {
+		go lk.keepAlive(ctx)
+	}
+
+	return lk, nil
+}
+
+func (l *locker) do(ctx context.Context, fn func(conn redigo.Conn) error) error {
+	conn, err := l.connectionPool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return fn(conn)
+}
+
+type lock struct {
+	locker   *locker
+	key      string
+	owner    string
+	token    int64
+	lost     chan struct{}
+	lostOnce sync.Once
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Key returns the locked key
+func (l *lock) Key() string {
+	return l.key
+}
+
+// Token returns the fencing token
+func (l *lock) Token() int64 {
+	return l.token
+}
+
+// Lost is closed when auto refresh fails to extend the lease
+func (l *lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Refresh extends the lease for the locker's ttl
+func (l *lock) Refresh(ctx context.Context) error {
+	return l.locker.do(ctx, func(conn redigo.Conn) error {
+		ok, err := refreshScript.Do(ctx, conn, l.key, l.owner, l.locker.ttl.Milliseconds()).Bool()
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return ErrLockLost
+		}
+
+		return nil
+	})
+}
+
+// Release stops auto refresh and deletes the lock if it's still owned
+func (l *lock) Release(ctx context.Context) error {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+
+	return l.locker.do(ctx, func(conn redigo.Conn) error {
+		ok, err := releaseScript.Do(ctx, conn, l.key, l.owner).Bool()
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return ErrLockLost
+		}
+
+		return nil
+	})
+}
+
+func (l *lock) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(l.locker.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			err := l.Refresh(ctx)
+			if err == nil || ctx.Err() != nil {
+				continue
+			}
+
+			log.Printf("failed to refresh lock %q: %v\n", l.key, err)
+			if errors.Is(err, ErrLockLost) {
+				l.lostOnce.Do(func() {
+					close(l.lost)
+				})
+				return
+			}
+		}
+	}
+}
+
+// fencingKey returns the key of the fencing counter in the same cluster slot as the lock key
+func fencingKey(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key + fencingKeySuffix
+		}
+	}
+
+	return "{" + key + "}" + fencingKeySuffix
+}
+
+func newOwner() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "failed to generate lock owner")
+	}
+
+	return hex.EncodeToString(buf), nil
+}
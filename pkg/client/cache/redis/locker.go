@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache/loader"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// unlockScript deletes the lock only if it's still owned by the caller
+var unlockScript = NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type locker struct {
+	connectionPool *redigo.Pool
+}
+
+// NewLocker returns a short-lived redis lock used by loader to coalesce loads across instances,
+// unlike lock package it issues no fencing tokens and leaves nothing behind once the lock expires
+func NewLocker(connectionPool *redigo.Pool) loader.Locker {
+	return &locker{connectionPool}
+}
+
+// TryLock acquires the lock for ttl or returns loader.ErrLockNotAcquired
+func (l *locker) TryLock(ctx context.Context, key string, ttl time.Duration) (loader.UnlockFunc, error) {
+	// redis expires keys with millisecond precision, PX 0 is rejected
+	if ttl < time.Millisecond {
+		return nil, errors.Errorf("lock ttl %s is shorter than a millisecond", ttl)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var reply interface{}
+	err = l.do(ctx, func(conn redigo.Conn) error {
+		reply, err = redigo.DoContext(conn, ctx, "SET", key, token, "NX", "PX", ttl.Milliseconds())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reply == nil {
+		return nil, loader.ErrLockNotAcquired
+	}
+
+	return func(ctx context.Context) error {
+		return l.do(ctx, func(conn redigo.Conn) error {
+			return unlockScript.Do(ctx, conn, key, token).Err()
+		})
+	}, nil
+}
+
+func (l *locker) do(ctx context.Context, fn func(conn redigo.Conn) error) error {
+	conn, err := l.connectionPool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("failed to close redis connection: %v\n", err)
+		}
+	}()
+
+	return fn(conn)
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "failed to generate lock token")
+	}
+
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,29 @@
+package redis
+
+import "github.com/andredubov/golibs/pkg/client/cache/metrics"
+
+// Option configures redis cache
+type Option func(o *options)
+
+type options struct {
+	metrics metrics.Metrics
+}
+
+// WithMetrics records latency, hits/misses, errors and pool statistics of the cache commands
+func WithMetrics(m metrics.Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		metrics: metrics.Nop(),
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
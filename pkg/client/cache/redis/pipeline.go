@@ -0,0 +1,163 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// ErrPipelineNotExecuted is returned by results of a pipeline which hasn't been executed yet
+var ErrPipelineNotExecuted = errors.New("pipeline is not executed")
+
+// Pipeline queues commands and sends them to redis in a single round trip
+type Pipeline interface {
+	// Queue adds the command to the pipeline, its result is available after Exec
+	Queue(cmd string, args ...interface{}) *Result
+	// Len returns the number of queued commands
+	Len() int
+	// Exec sends queued commands over one connection and receives their replies, the returned error
+	// concerns the connection only, errors of particular commands are kept in their results
+	Exec(ctx context.Context) error
+}
+
+// Result is a reply of a pipelined command
+type Result struct {
+	cmd   string
+	args  []interface{}
+	reply interface{}
+	err   error
+}
+
+// Value returns raw reply of the command
+func (r *Result) Value() (interface{}, error) {
+	return r.reply, r.err
+}
+
+// Err returns error of the command
+func (r *Result) Err() error {
+	return r.err
+}
+
+// String returns reply of the command as string
+func (r *Result) String() (string, error) {
+	return redigo.String(r.reply, r.err)
+}
+
+// Bytes returns reply of the command as bytes
+func (r *Result) Bytes() ([]byte, error) {
+	return redigo.Bytes(r.reply, r.err)
+}
+
+// Int64 returns reply of the command as int64
+func (r *Result) Int64() (int64, error) {
+	return redigo.Int64(r.reply, r.err)
+}
+
+// Float64 returns reply of the command as float64
+func (r *Result) Float64() (float64, error) {
+	return redigo.Float64(r.reply, r.err)
+}
+
+// Bool returns reply of the command as bool
+func (r *Result) Bool() (bool, error) {
+	return redigo.Bool(r.reply, r.err)
+}
+
+// Values returns reply of the command as slice
+func (r *Result) Values() ([]interface{}, error) {
+	return redigo.Values(r.reply, r.err)
+}
+
+// Strings returns reply of the command as slice of strings
+func (r *Result) Strings() ([]string, error) {
+	return redigo.Strings(r.reply, r.err)
+}
+
+// Int64s returns reply of the command as slice of int64
+func (r *Result) Int64s() ([]int64, error) {
+	return redigo.Int64s(r.reply, r.err)
+}
+
+// StringMap returns reply of the command as map of strings
+func (r *Result) StringMap() (map[string]string, error) {
+	return redigo.StringMap(r.reply, r.err)
+}
+
+type pipeline struct {
+	connectionPool *redigo.Pool
+	commandTimeout time.Duration
+	results        []*Result
+}
+
+// NewPipeline returns a new empty pipeline, commandTimeout limits Exec unless its ctx has a deadline
+func NewPipeline(connectionPool *redigo.Pool, commandTimeout time.Duration) Pipeline {
+	return &pipeline{
+		connectionPool: connectionPool,
+		commandTimeout: commandTimeout,
+	}
+}
+
+// Queue adds the command to the pipeline
+func (p *pipeline) Queue(cmd string, args ...interface{}) *Result {
+	result := &Result{cmd: cmd, args: args, err: ErrPipelineNotExecuted}
+	p.results = append(p.results, result)
+
+	return result
+}
+
+// Len returns the number of queued commands
+func (p *pipeline) Len() int {
+	return len(p.results)
+}
+
+// Exec sends queued commands and receives their replies, the pipeline is emptied afterwards
+func (p *pipeline) Exec(ctx context.Context) error {
+	results := p.results
+	p.results = nil
+
+	if len(results) == 0 {
+		return nil
+	}
+
+	ctx, cancel := withCommandTimeout(ctx, p.commandTimeout)
+	defer cancel()
+
+	fail := func(err error) error {
+		err = contextError(ctx, err)
+		for _, result := range results {
+			result.reply, result.err = nil, err
+		}
+
+		return err
+	}
+
+	conn, err := p.connectionPool.GetContext(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+
+	for _, result := range results {
+		if err = conn.Send(result.cmd, result.args...); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err = conn.Flush(); err != nil {
+		return fail(err)
+	}
+
+	for _, result := range results {
+		result.reply, result.err = redigo.ReceiveContext(conn, ctx)
+		result.err = contextError(ctx, replyError(result.err))
+	}
+
+	// connection errors are sticky, so the last reply tells whether the connection has failed
+	if err = conn.Err(); err != nil {
+		return contextError(ctx, err)
+	}
+
+	return nil
+}
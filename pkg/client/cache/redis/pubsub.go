@@ -0,0 +1,320 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	messagesBufferSize     = 64
+	minResubscribeInterval = 100 * time.Millisecond
+	maxResubscribeInterval = 10 * time.Second
+	// an idle subscription is pinged, so that a dead connection is detected without relying on the read timeout of the pool
+	subscriptionPingInterval = 30 * time.Second
+	subscriptionReadTimeout  = subscriptionPingInterval + 10*time.Second
+)
+
+// ErrPubSubClosed is returned when subscribing on a closed PubSub
+var ErrPubSubClosed = errors.New("pubsub is closed")
+
+// Message is a message received from a subscribed channel
+type Message struct {
+	Channel string
+	Pattern string
+	Data    []byte
+}
+
+// PubSub interface for publishing and receiving messages through redis channels
+type PubSub interface {
+	Publish(ctx context.Context, channel string, message interface{}) (int, error)
+	Subscribe(ctx context.Context, channels ...string) (Subscription, error)
+	PSubscribe(ctx context.Context, patterns ...string) (Subscription, error)
+	Close() error
+}
+
+// Subscription delivers messages of subscribed channels until ctx passed to Subscribe is done
+// or all the channels are unsubscribed, the subscription is restored automatically after reconnect
+type Subscription interface {
+	// Messages returns a channel of received messages, it's closed when the subscription ends
+	Messages() <-chan Message
+	// Subscribed is signalled every time the subscription is (re)established,
+	// messages published while the subscription was broken are lost
+	Subscribed() <-chan struct{}
+	// Unsubscribe removes the channels (patterns) from the subscription, all of them if none specified
+	Unsubscribe(channels ...string) error
+}
+
+type pubSub struct {
+	connectionPool *redigo.Pool
+	mu             sync.Mutex
+	closed         bool
+	subscriptions  map[*subscription]struct{}
+	wg             sync.WaitGroup
+}
+
+// NewPubSub returns a new instance of PubSub built on the connection pool
+func NewPubSub(connectionPool *redigo.Pool) PubSub {
+	return &pubSub{
+		connectionPool: connectionPool,
+		subscriptions:  make(map[*subscription]struct{}),
+	}
+}
+
+// Publish posts the message to the channel, returns the number of clients that received it
+func (p *pubSub) Publish(ctx context.Context, channel string, message interface{}) (int, error) {
+	conn, err := p.connectionPool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return redigo.Int(redigo.DoContext(conn, ctx, "PUBLISH", channel, message))
+}
+
+// Subscribe subscribes to the channels
+func (p *pubSub) Subscribe(ctx context.Context, channels ...string) (Subscription, error) {
+	return p.subscribe(ctx, false, channels)
+}
+
+// PSubscribe subscribes to the channels matching the patterns
+func (p *pubSub) PSubscribe(ctx context.Context, patterns ...string) (Subscription, error) {
+	return p.subscribe(ctx, true, patterns)
+}
+
+// Close ends all subscriptions and waits for them to release connections
+func (p *pubSub) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for s := range p.subscriptions {
+		s.cancel()
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+
+	return nil
+}
+
+func (p *pubSub) subscribe(ctx context.Context, pattern bool, channels []string) (Subscription, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("no channels to subscribe")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrPubSubClosed
+	}
+
+	s := &subscription{
+		connectionPool: p.connectionPool,
+		pattern:        pattern,
+		channels:       make(map[string]struct{}, len(channels)),
+		messages:       make(chan Message, messagesBufferSize),
+		subscribed:     make(chan struct{}, 1),
+	}
+	for _, channel := range channels {
+		s.channels[channel] = struct{}{}
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+
+	p.subscriptions[s] = struct{}{}
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+		s.run()
+
+		p.mu.Lock()
+		delete(p.subscriptions, s)
+		p.mu.Unlock()
+	}()
+
+	return s, nil
+}
+
+type subscription struct {
+	connectionPool *redigo.Pool
+	pattern        bool
+	ctx            context.Context
+	cancel         context.CancelFunc
+	mu             sync.Mutex
+	conn           *redigo.PubSubConn
+	channels       map[string]struct{}
+	messages       chan Message
+	subscribed     chan struct{}
+}
+
+// Messages returns a channel of received messages
+func (s *subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// Subscribed is signalled every time the subscription is (re)established
+func (s *subscription) Subscribed() <-chan struct{} {
+	return s.subscribed
+}
+
+// Unsubscribe removes the channels from the subscription
+func (s *subscription) Unsubscribe(channels ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(channels) == 0 {
+		channels = make([]string, 0, len(s.channels))
+		for channel := range s.channels {
+			channels = append(channels, channel)
+		}
+	}
+
+	for _, channel := range channels {
+		delete(s.channels, channel)
+	}
+
+	if len(s.channels) == 0 {
+		s.cancel()
+		return nil
+	}
+
+	if s.conn == nil {
+		return nil
+	}
+
+	args := redigo.Args{}.AddFlat(channels)
+	if s.pattern {
+		return s.conn.PUnsubscribe(args...)
+	}
+
+	return s.conn.Unsubscribe(args...)
+}
+
+func (s *subscription) run() {
+	defer close(s.messages)
+
+	interval := minResubscribeInterval
+	for {
+		established, err := s.listen()
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		if established {
+			interval = minResubscribeInterval
+		}
+
+		log.Printf("redis subscription failed, resubscribing in %s: %v\n", interval, err)
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		if interval *= 2; interval > maxResubscribeInterval {
+			interval = maxResubscribeInterval
+		}
+	}
+}
+
+// listen receives messages until the connection fails, reports whether the subscription was established
+func (s *subscription) listen() (bool, error) {
+	conn, err := s.connectionPool.GetContext(s.ctx)
+	if err != nil {
+		return false, err
+	}
+
+	psc := &redigo.PubSubConn{Conn: conn}
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+
+		psc.Close()
+	}()
+
+	s.mu.Lock()
+	args := make(redigo.Args, 0, len(s.channels))
+	for channel := range s.channels {
+		args = append(args, channel)
+	}
+
+	if s.pattern {
+		err = psc.PSubscribe(args...)
+	} else {
+		err = psc.Subscribe(args...)
+	}
+	s.conn = psc
+	s.mu.Unlock()
+
+	if err != nil {
+		return false, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.keepAlive(psc, done)
+
+	established := false
+	for {
+		switch v := psc.ReceiveWithTimeout(subscriptionReadTimeout).(type) {
+		case redigo.Message:
+			select {
+			case s.messages <- Message{Channel: v.Channel, Pattern: v.Pattern, Data: v.Data}:
+			case <-s.ctx.Done():
+				return established, s.ctx.Err()
+			}
+		case redigo.Subscription:
+			if v.Count == 0 && s.ctx.Err() != nil {
+				return established, s.ctx.Err()
+			}
+
+			if !established && (v.Kind == "subscribe" || v.Kind == "psubscribe") {
+				established = true
+				select {
+				case s.subscribed <- struct{}{}:
+				default:
+				}
+			}
+		case error:
+			return established, v
+		}
+	}
+}
+
+// keepAlive pings the subscription connection until done, when ctx is done it unsubscribes
+// from all channels, so that the listener wakes up and returns
+func (s *subscription) keepAlive(psc *redigo.PubSubConn, done <-chan struct{}) {
+	ticker := time.NewTicker(subscriptionPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.ctx.Done():
+			s.mu.Lock()
+			if s.pattern {
+				_ = psc.PUnsubscribe()
+			} else {
+				_ = psc.Unsubscribe()
+			}
+			s.mu.Unlock()
+
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			err := psc.Ping("")
+			s.mu.Unlock()
+
+			if err != nil {
+				return
+			}
+		}
+	}
+}
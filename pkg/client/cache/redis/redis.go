@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/andredubov/golibs/pkg/client/cache"
+	"github.com/andredubov/golibs/pkg/client/cache/metrics"
 	"github.com/andredubov/golibs/pkg/config"
 	redigo "github.com/gomodule/redigo/redis"
 )
@@ -14,21 +15,28 @@ type handler func(ctx context.Context, conn redigo.Conn) error
 
 type rd struct {
 	connectionPool *redigo.Pool
+	replicaPool    *redigo.Pool
+	cluster        *cluster
 	config         config.RedisConfig
+	metrics        metrics.Metrics
 }
 
 // NewCache returns a new instance of redis struct
-func NewCache(connectionPool *redigo.Pool, config config.RedisConfig) cache.Cache {
-	return &rd{
-		connectionPool,
-		config,
+func NewCache(connectionPool *redigo.Pool, config config.RedisConfig, opts ...Option) cache.Cache {
+	r := &rd{
+		connectionPool: connectionPool,
+		config:         config,
+		metrics:        newOptions(opts).metrics,
 	}
+	r.collectPoolStats()
+
+	return r
 }
 
 // Set binds key and its value
 func (r *rd) Set(ctx context.Context, key string, value interface{}) error {
-	err := r.execute(ctx, func(ctx context.Context, conn redigo.Conn) error {
-		if _, err := conn.Do("SET", redigo.Args{key}.Add(value)...); err != nil {
+	err := r.execute(ctx, key, func(ctx context.Context, conn redigo.Conn) error {
+		if _, err := redigo.DoContext(conn, ctx, "SET", redigo.Args{key}.Add(value)...); err != nil {
 			return err
 		}
 
@@ -42,12 +50,69 @@ func (r *rd) Set(ctx context.Context, key string, value interface{}) error {
 	return nil
 }
 
-// Get returns value by its key from cache
+// SetWithOptions binds key and its value atomically applying expiration and write condition
+func (r *rd) SetWithOptions(ctx context.Context, key string, value interface{}, opts cache.SetOptions) (bool, interface{}, error) {
+	if err := opts.Validate(); err != nil {
+		return false, nil, err
+	}
+
+	args := redigo.Args{key}.Add(value)
+	switch {
+	case opts.TTL > 0 && opts.TTL%time.Second == 0:
+		args = args.Add("EX", int64(opts.TTL/time.Second))
+	case opts.TTL > 0:
+		args = args.Add("PX", opts.TTL.Milliseconds())
+	case opts.KeepTTL:
+		args = args.Add("KEEPTTL")
+	}
+
+	switch opts.Mode {
+	case cache.SetIfNotExists:
+		args = args.Add("NX")
+	case cache.SetIfExists:
+		args = args.Add("XX")
+	}
+
+	if opts.Get {
+		args = args.Add("GET")
+	}
+
+	var reply interface{}
+	err := r.execute(ctx, key, func(ctx context.Context, conn redigo.Conn) error {
+		var errEx error
+		reply, errEx = redigo.DoContext(conn, ctx, "SET", args...)
+		if errEx != nil {
+			return errEx
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	if !opts.Get {
+		return reply != nil, nil, nil
+	}
+
+	// with GET the reply is the previous value, so the write result follows from the condition
+	switch opts.Mode {
+	case cache.SetIfNotExists:
+		return reply == nil, reply, nil
+	case cache.SetIfExists:
+		return reply != nil, reply, nil
+	default:
+		return true, reply, nil
+	}
+}
+
+// Get returns value by its key from cache, cache.ErrNotFound if the key doesn't exist
 func (r *rd) Get(ctx context.Context, key string) (interface{}, error) {
 	var value interface{}
-	err := r.execute(ctx, func(ctx context.Context, conn redigo.Conn) error {
+	err := r.executeRead(ctx, key, func(ctx context.Context, conn redigo.Conn) error {
 		var errEx error
-		value, errEx = conn.Do("GET", key)
+		value, errEx = redigo.DoContext(conn, ctx, "GET", key)
 		if errEx != nil {
 			return errEx
 		}
@@ -59,13 +124,88 @@ func (r *rd) Get(ctx context.Context, key string) (interface{}, error) {
 		return nil, err
 	}
 
+	r.countLookup("GET", value != nil)
+	if value == nil {
+		return nil, cache.ErrNotFound
+	}
+
 	return value, nil
 }
 
+// MSet binds keys and their values in a single round trip
+func (r *rd) MSet(ctx context.Context, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	err := r.execute(ctx, anyKey(values), func(ctx context.Context, conn redigo.Conn) error {
+		if _, err := redigo.DoContext(conn, ctx, "MSET", redigo.Args{}.AddFlat(values)...); err != nil {
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MGet returns values by their keys from cache, missing keys have nil values
+func (r *rd) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return []interface{}{}, nil
+	}
+
+	var values []interface{}
+	err := r.executeRead(ctx, keys[0], func(ctx context.Context, conn redigo.Conn) error {
+		var errEx error
+		values, errEx = redigo.Values(redigo.DoContext(conn, ctx, "MGET", redigo.Args{}.AddFlat(keys)...))
+		if errEx != nil {
+			return errEx
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range values {
+		r.countLookup("MGET", value != nil)
+	}
+
+	return values, nil
+}
+
+// MDelete deletes values by their keys into cache in a single round trip
+func (r *rd) MDelete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	err := r.execute(ctx, keys[0], func(ctx context.Context, conn redigo.Conn) error {
+		if _, err := redigo.DoContext(conn, ctx, "DEL", redigo.Args{}.AddFlat(keys)...); err != nil {
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // HashSet binds key and value pair to the hash into cache
 func (r *rd) HashSet(ctx context.Context, hash string, values interface{}) error {
-	err := r.execute(ctx, func(ctx context.Context, conn redigo.Conn) error {
-		if _, err := conn.Do("HSET", redigo.Args{hash}.AddFlat(values)...); err != nil {
+	err := r.execute(ctx, hash, func(ctx context.Context, conn redigo.Conn) error {
+		if _, err := redigo.DoContext(conn, ctx, "HSET", redigo.Args{hash}.AddFlat(values)...); err != nil {
 			return err
 		}
 
@@ -79,12 +219,66 @@ func (r *rd) HashSet(ctx context.Context, hash string, values interface{}) error
 	return nil
 }
 
-// HashGetAll returns a sequence of key-value pairs of the corresponding hash
+// HashGetAll returns a sequence of key-value pairs of the corresponding hash, cache.ErrNotFound if the hash doesn't exist
 func (r *rd) HashGetAll(ctx context.Context, hash string) ([]interface{}, error) {
 	var values []interface{}
-	err := r.execute(ctx, func(ctx context.Context, conn redigo.Conn) error {
+	err := r.executeRead(ctx, hash, func(ctx context.Context, conn redigo.Conn) error {
+		var errEx error
+		values, errEx = redigo.Values(redigo.DoContext(conn, ctx, "HGETALL", hash))
+		if errEx != nil {
+			return errEx
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.countLookup("HGETALL", len(values) != 0)
+	if len(values) == 0 {
+		return nil, cache.ErrNotFound
+	}
+
+	return values, nil
+}
+
+// HashGet returns the value of the hash field, cache.ErrNotFound if the field doesn't exist
+func (r *rd) HashGet(ctx context.Context, hash string, field string) (interface{}, error) {
+	var value interface{}
+	err := r.executeRead(ctx, hash, func(ctx context.Context, conn redigo.Conn) error {
+		var errEx error
+		value, errEx = redigo.DoContext(conn, ctx, "HGET", hash, field)
+		if errEx != nil {
+			return errEx
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.countLookup("HGET", value != nil)
+	if value == nil {
+		return nil, cache.ErrNotFound
+	}
+
+	return value, nil
+}
+
+// HashMGet returns values of the hash fields, nil for missing ones
+func (r *rd) HashMGet(ctx context.Context, hash string, fields ...string) ([]interface{}, error) {
+	if len(fields) == 0 {
+		return []interface{}{}, nil
+	}
+
+	var values []interface{}
+	err := r.executeRead(ctx, hash, func(ctx context.Context, conn redigo.Conn) error {
 		var errEx error
-		values, errEx = redigo.Values(conn.Do("HGETALL", hash))
+		values, errEx = redigo.Values(redigo.DoContext(conn, ctx, "HMGET", redigo.Args{hash}.AddFlat(fields)...))
 		if errEx != nil {
 			return errEx
 		}
@@ -96,13 +290,159 @@ func (r *rd) HashGetAll(ctx context.Context, hash string) ([]interface{}, error)
 		return nil, err
 	}
 
+	for _, value := range values {
+		r.countLookup("HMGET", value != nil)
+	}
+
 	return values, nil
 }
 
+// HashDel deletes the hash fields, returns the number of deleted fields
+func (r *rd) HashDel(ctx context.Context, hash string, fields ...string) (int64, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+
+	var deleted int64
+	err := r.execute(ctx, hash, func(ctx context.Context, conn redigo.Conn) error {
+		var errEx error
+		deleted, errEx = redigo.Int64(redigo.DoContext(conn, ctx, "HDEL", redigo.Args{hash}.AddFlat(fields)...))
+		if errEx != nil {
+			return errEx
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// HashIncrBy increments the integer value of the hash field, returns the value after the increment
+func (r *rd) HashIncrBy(ctx context.Context, hash string, field string, increment int64) (int64, error) {
+	var value int64
+	err := r.execute(ctx, hash, func(ctx context.Context, conn redigo.Conn) error {
+		var errEx error
+		value, errEx = redigo.Int64(redigo.DoContext(conn, ctx, "HINCRBY", hash, field, increment))
+		if errEx != nil {
+			return errEx
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// HashExists reports whether the hash field exists
+func (r *rd) HashExists(ctx context.Context, hash string, field string) (bool, error) {
+	var ok bool
+	err := r.executeRead(ctx, hash, func(ctx context.Context, conn redigo.Conn) error {
+		var errEx error
+		ok, errEx = redigo.Bool(redigo.DoContext(conn, ctx, "HEXISTS", hash, field))
+		if errEx != nil {
+			return errEx
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// HashScanStruct reads the hash into fields of the struct pointed by dest, see redigo.ScanStruct for `redis:"field"` tags
+func (r *rd) HashScanStruct(ctx context.Context, hash string, dest interface{}) error {
+	values, err := r.HashGetAll(ctx, hash)
+	if err != nil {
+		return err
+	}
+
+	return redigo.ScanStruct(values, dest)
+}
+
+// HashExpire sets time to expire the hash fields with millisecond precision (HPEXPIRE), reports
+// for each field whether it exists, a non-positive expiration deletes the fields
+func (r *rd) HashExpire(ctx context.Context, hash string, expiration time.Duration, fields ...string) ([]bool, error) {
+	if len(fields) == 0 {
+		return []bool{}, nil
+	}
+
+	var codes []int64
+	err := r.execute(ctx, hash, func(ctx context.Context, conn redigo.Conn) error {
+		args := redigo.Args{hash, expiration.Milliseconds(), "FIELDS", len(fields)}.AddFlat(fields)
+
+		var errEx error
+		codes, errEx = redigo.Int64s(redigo.DoContext(conn, ctx, "HPEXPIRE", args...))
+		if errEx != nil {
+			return fieldTTLError(errEx)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// -2 means a missing field, 1 the expiration is set and 2 the field is deleted
+	result := make([]bool, len(codes))
+	for i, code := range codes {
+		result[i] = code > 0
+	}
+
+	return result, nil
+}
+
+// HashTTL returns remaining time to live of the hash fields, cache.NoExpiration or cache.KeyNotExists for each field
+func (r *rd) HashTTL(ctx context.Context, hash string, fields ...string) ([]time.Duration, error) {
+	if len(fields) == 0 {
+		return []time.Duration{}, nil
+	}
+
+	var ttls []int64
+	err := r.executeRead(ctx, hash, func(ctx context.Context, conn redigo.Conn) error {
+		args := redigo.Args{hash, "FIELDS", len(fields)}.AddFlat(fields)
+
+		var errEx error
+		ttls, errEx = redigo.Int64s(redigo.DoContext(conn, ctx, "HPTTL", args...))
+		if errEx != nil {
+			return fieldTTLError(errEx)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]time.Duration, len(ttls))
+	for i, ttl := range ttls {
+		if ttl < 0 {
+			result[i] = time.Duration(ttl)
+		} else {
+			result[i] = time.Duration(ttl) * time.Millisecond
+		}
+	}
+
+	return result, nil
+}
+
 // Expire sets time to expire key into cache
 func (r *rd) Expire(ctx context.Context, key string, expiration time.Duration) error {
-	err := r.execute(ctx, func(ctx context.Context, conn redigo.Conn) error {
-		if _, err := conn.Do("EXPIRE", key, int(expiration.Seconds())); err != nil {
+	err := r.execute(ctx, key, func(ctx context.Context, conn redigo.Conn) error {
+		if _, err := redigo.DoContext(conn, ctx, "PEXPIRE", key, expiration.Milliseconds()); err != nil {
 			return err
 		}
 
@@ -116,10 +456,77 @@ func (r *rd) Expire(ctx context.Context, key string, expiration time.Duration) e
 	return nil
 }
 
+// PExpire sets time to expire key into cache with millisecond precision, reports whether the key exists
+func (r *rd) PExpire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	var ok bool
+	err := r.execute(ctx, key, func(ctx context.Context, conn redigo.Conn) error {
+		var errEx error
+		ok, errEx = redigo.Bool(redigo.DoContext(conn, ctx, "PEXPIRE", key, expiration.Milliseconds()))
+		if errEx != nil {
+			return errEx
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// TTL returns remaining time to live of the key or cache.NoExpiration, cache.ErrNotFound if the key doesn't exist
+func (r *rd) TTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl int64
+	err := r.executeRead(ctx, key, func(ctx context.Context, conn redigo.Conn) error {
+		var errEx error
+		ttl, errEx = redigo.Int64(redigo.DoContext(conn, ctx, "PTTL", key))
+		if errEx != nil {
+			return errEx
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case ttl == int64(cache.KeyNotExists):
+		return 0, cache.ErrNotFound
+	case ttl < 0:
+		return time.Duration(ttl), nil
+	}
+
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+// Persist removes expiration of the key, reports whether the expiration was removed
+func (r *rd) Persist(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := r.execute(ctx, key, func(ctx context.Context, conn redigo.Conn) error {
+		var errEx error
+		ok, errEx = redigo.Bool(redigo.DoContext(conn, ctx, "PERSIST", key))
+		if errEx != nil {
+			return errEx
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
 // Delete a value by its key into cache
 func (r *rd) Delete(ctx context.Context, key string) error {
-	err := r.execute(ctx, func(ctx context.Context, conn redigo.Conn) error {
-		_, err := conn.Do("DEL", key)
+	err := r.execute(ctx, key, func(ctx context.Context, conn redigo.Conn) error {
+		_, err := redigo.DoContext(conn, ctx, "DEL", key)
 		if err != nil {
 			return err
 		}
@@ -136,8 +543,8 @@ func (r *rd) Delete(ctx context.Context, key string) error {
 
 // Ping tests cache connection
 func (r *rd) Ping(ctx context.Context) error {
-	err := r.execute(ctx, func(ctx context.Context, conn redigo.Conn) error {
-		if _, err := conn.Do("PING"); err != nil {
+	err := r.execute(ctx, "", func(ctx context.Context, conn redigo.Conn) error {
+		if _, err := redigo.DoContext(conn, ctx, "PING"); err != nil {
 			return err
 		}
 
@@ -153,15 +560,82 @@ func (r *rd) Ping(ctx context.Context) error {
 
 // Close closes cache connection
 func (r *rd) Close() error {
+	if r.cluster != nil {
+		return r.cluster.close()
+	}
+
+	if r.replicaPool != nil {
+		if err := r.replicaPool.Close(); err != nil {
+			log.Printf("failed to close redis replica pool: %v\n", err)
+		}
+	}
+
 	return r.connectionPool.Close()
 }
 
-func (r *rd) execute(ctx context.Context, handler handler) error {
-	connection, err := r.getConnect(ctx)
+// executeRead runs read-only handler on a replica if reading from replicas is enabled
+func (r *rd) executeRead(ctx context.Context, key string, handler handler) error {
+	if r.replicaPool == nil {
+		return r.execute(ctx, key, handler)
+	}
+
+	ctx, cancel := r.commandContext(ctx)
+	defer cancel()
+
+	return contextError(ctx, replyError(r.executeOn(ctx, r.replicaPool, handler)))
+}
+
+// runScript runs the script with the command timeout, metrics and error mapping of the cache
+func (r *rd) runScript(ctx context.Context, script *Script, keysAndArgs ...interface{}) *Result {
+	var result *Result
+	err := r.execute(ctx, "", func(ctx context.Context, conn redigo.Conn) error {
+		result = script.Do(ctx, conn, keysAndArgs...)
+		return result.err
+	})
+
+	if result == nil {
+		return &Result{err: err}
+	}
+	result.err = err
+
+	return result
+}
+
+// execute runs handler on a connection serving the key
+func (r *rd) execute(ctx context.Context, key string, handler handler) error {
+	ctx, cancel := r.commandContext(ctx)
+	defer cancel()
+
+	if r.cluster != nil {
+		return contextError(ctx, replyError(r.cluster.route(ctx, key, r.instrument(handler))))
+	}
+
+	return contextError(ctx, replyError(r.executeOn(ctx, r.connectionPool, handler)))
+}
+
+// commandContext limits the command by the default timeout unless ctx already has a deadline
+func (r *rd) commandContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withCommandTimeout(ctx, r.config.CommandTimeout())
+}
+
+// withCommandTimeout limits ctx by the timeout unless it already has a deadline, zero timeout means no limit
+func withCommandTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (r *rd) executeOn(ctx context.Context, pool *redigo.Pool, handler handler) error {
+	connection, err := r.getConnect(ctx, pool)
 	if err != nil {
+		r.metrics.IncError("GETCONN", errorKind(err))
 		return err
 	}
 
+	handler = r.instrument(handler)
+
 	defer func() {
 		if err = connection.Close(); err != nil {
 			log.Printf("failed to close redis connection: %v\n", err)
@@ -175,19 +649,65 @@ func (r *rd) execute(ctx context.Context, handler handler) error {
 	return nil
 }
 
-func (r *rd) getConnect(ctx context.Context) (redigo.Conn, error) {
+func (r *rd) getConnect(ctx context.Context, pool *redigo.Pool) (redigo.Conn, error) {
 	getConnTimeoutCtx, cancel := context.WithTimeout(ctx, r.config.ConnectionTimeout())
 	defer cancel()
 
-	conn, err := r.connectionPool.GetContext(getConnTimeoutCtx)
+	// the pool returns an unusable connection on error, there is nothing to close
+	conn, err := pool.GetContext(getConnTimeoutCtx)
 	if err != nil {
 		log.Printf("failed to get redis connection: %v\n", err)
-		if err := conn.Close(); err != nil {
-			return nil, err
-		}
-
 		return nil, err
 	}
 
 	return conn, nil
 }
+
+func anyKey(values map[string]interface{}) string {
+	for key := range values {
+		return key
+	}
+
+	return ""
+}
+
+// instrument makes handler record metrics of the commands it sends
+func (r *rd) instrument(handler handler) handler {
+	return func(ctx context.Context, conn redigo.Conn) error {
+		return handler(ctx, &instrumentedConn{Conn: conn, metrics: r.metrics})
+	}
+}
+
+// collectPoolStats lets metrics read statistics of the cache pools when they are scraped
+func (r *rd) collectPoolStats() {
+	if collector, ok := r.metrics.(metrics.PoolStatsCollector); ok {
+		collector.CollectPoolStats(r.poolStats)
+	}
+}
+
+// poolStats sums statistics of all pools, so that master, replica and cluster node pools don't overwrite each other
+func (r *rd) poolStats() cache.PoolStats {
+	var stats cache.PoolStats
+	if r.cluster != nil {
+		for _, pool := range r.cluster.allPools() {
+			stats = addPoolStats(stats, poolStats(pool))
+		}
+
+		return stats
+	}
+
+	stats = poolStats(r.connectionPool)
+	if r.replicaPool != nil {
+		stats = addPoolStats(stats, poolStats(r.replicaPool))
+	}
+
+	return stats
+}
+
+func (r *rd) countLookup(command string, hit bool) {
+	if hit {
+		r.metrics.IncHit(command)
+	} else {
+		r.metrics.IncMiss(command)
+	}
+}
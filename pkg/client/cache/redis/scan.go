@@ -0,0 +1,223 @@
+package redis
+
+import (
+	"context"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const defaultDeleteBatchSize = 500
+
+// ScanOptions are hints of SCAN family commands
+type ScanOptions struct {
+	// Match filters elements by glob-style pattern
+	Match string
+	// Count is the amount of work done per round trip
+	Count int
+	// Type filters keys by their type, it's used by SCAN only
+	Type string
+}
+
+// Iterator walks over a SCAN family cursor, it may return an element more than once
+// if the collection is modified during the iteration
+type Iterator interface {
+	// Next advances the iterator, it returns false when the iteration is over or failed
+	Next(ctx context.Context) bool
+	// Val returns the current element, HSCAN and ZSCAN return fields (members) and values (scores) alternately
+	Val() string
+	// Err returns the error that stopped the iteration
+	Err() error
+}
+
+// Scanner interface for iterating keys and collections without blocking redis
+type Scanner interface {
+	Scan(opts ScanOptions) Iterator
+	HScan(key string, opts ScanOptions) Iterator
+	SScan(key string, opts ScanOptions) Iterator
+	ZScan(key string, opts ScanOptions) Iterator
+	// DeleteByPattern unlinks keys matching the pattern in batches, returns the number of removed keys
+	DeleteByPattern(ctx context.Context, pattern string, batchSize int) (int64, error)
+}
+
+type scanner struct {
+	connectionPool *redigo.Pool
+}
+
+// NewScanner returns a new instance of scanner
+func NewScanner(connectionPool *redigo.Pool) Scanner {
+	return &scanner{connectionPool}
+}
+
+// Scan iterates keys of the database
+func (s *scanner) Scan(opts ScanOptions) Iterator {
+	args := scanArgs(opts)
+	if opts.Type != "" {
+		args = args.Add("TYPE", opts.Type)
+	}
+
+	return &iterator{connectionPool: s.connectionPool, cmd: "SCAN", args: args}
+}
+
+// HScan iterates fields and values of the hash
+func (s *scanner) HScan(key string, opts ScanOptions) Iterator {
+	return &iterator{connectionPool: s.connectionPool, cmd: "HSCAN", key: key, args: scanArgs(opts)}
+}
+
+// SScan iterates members of the set
+func (s *scanner) SScan(key string, opts ScanOptions) Iterator {
+	return &iterator{connectionPool: s.connectionPool, cmd: "SSCAN", key: key, args: scanArgs(opts)}
+}
+
+// ZScan iterates members and scores of the sorted set
+func (s *scanner) ZScan(key string, opts ScanOptions) Iterator {
+	return &iterator{connectionPool: s.connectionPool, cmd: "ZSCAN", key: key, args: scanArgs(opts)}
+}
+
+// DeleteByPattern unlinks keys matching the pattern in batches
+func (s *scanner) DeleteByPattern(ctx context.Context, pattern string, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = defaultDeleteBatchSize
+	}
+
+	var deleted int64
+	unlink := func(keys []string) error {
+		conn, err := s.connectionPool.GetContext(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		n, err := redigo.Int64(redigo.DoContext(conn, ctx, "UNLINK", redigo.Args{}.AddFlat(keys)...))
+		if err != nil {
+			return errors.Wrap(err, "failed to unlink keys")
+		}
+		deleted += n
+
+		return nil
+	}
+
+	it := s.Scan(ScanOptions{Match: pattern, Count: batchSize})
+	batch := make([]string, 0, batchSize)
+	for it.Next(ctx) {
+		batch = append(batch, it.Val())
+		if len(batch) < batchSize {
+			continue
+		}
+
+		if err := unlink(batch); err != nil {
+			return deleted, err
+		}
+		batch = batch[:0]
+	}
+
+	if err := it.Err(); err != nil {
+		return deleted, err
+	}
+
+	if len(batch) > 0 {
+		if err := unlink(batch); err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+type iterator struct {
+	connectionPool *redigo.Pool
+	cmd            string
+	key            string
+	args           redigo.Args
+	cursor         string
+	started        bool
+	page           []string
+	val            string
+	err            error
+}
+
+// Next advances the iterator fetching the next page when needed
+func (it *iterator) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if it.err != nil || (it.started && it.cursor == "0") {
+			return false
+		}
+
+		if err := ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+
+		if it.err = it.fetch(ctx); it.err != nil {
+			return false
+		}
+	}
+
+	it.val, it.page = it.page[0], it.page[1:]
+
+	return true
+}
+
+// Val returns the current element
+func (it *iterator) Val() string {
+	return it.val
+}
+
+// Err returns the error that stopped the iteration
+func (it *iterator) Err() error {
+	return it.err
+}
+
+func (it *iterator) fetch(ctx context.Context) error {
+	conn, err := it.connectionPool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cursor := it.cursor
+	if !it.started {
+		cursor = "0"
+	}
+
+	// HSCAN, SSCAN and ZSCAN take the key even if it's empty, SCAN has none
+	args := redigo.Args{}
+	if it.cmd != "SCAN" {
+		args = args.Add(it.key)
+	}
+	args = args.Add(cursor).Add(it.args...)
+
+	reply, err := redigo.Values(redigo.DoContext(conn, ctx, it.cmd, args...))
+	if err != nil {
+		return errors.Wrapf(err, "failed to %s", it.cmd)
+	}
+
+	if len(reply) != 2 {
+		return errors.Errorf("unexpected %s reply length %d", it.cmd, len(reply))
+	}
+
+	if it.cursor, err = redigo.String(reply[0], nil); err != nil {
+		return err
+	}
+
+	if it.page, err = redigo.Strings(reply[1], nil); err != nil {
+		return err
+	}
+
+	it.started = true
+
+	return nil
+}
+
+func scanArgs(opts ScanOptions) redigo.Args {
+	args := redigo.Args{}
+	if opts.Match != "" {
+		args = args.Add("MATCH", opts.Match)
+	}
+
+	if opts.Count > 0 {
+		args = args.Add("COUNT", opts.Count)
+	}
+
+	return args
+}
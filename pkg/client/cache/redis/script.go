@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+var (
+	registryMu sync.Mutex
+	registry   []*Script
+)
+
+// Script is a lua script executed by its SHA1 digest, the source is sent only when the server doesn't know it yet
+type Script struct {
+	keyCount int
+	src      string
+	hash     string
+}
+
+// NewScript returns a new script registered for preloading, keyCount is the number of KEYS
+// passed to the script, if it's negative the count is passed as the first argument on every call
+func NewScript(keyCount int, src string) *Script {
+	digest := sha1.Sum([]byte(src))
+	script := &Script{
+		keyCount: keyCount,
+		src:      src,
+		hash:     hex.EncodeToString(digest[:]),
+	}
+
+	registryMu.Lock()
+	registry = append(registry, script)
+	registryMu.Unlock()
+
+	return script
+}
+
+// Hash returns SHA1 digest of the script
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// Do runs the script with EVALSHA falling back to EVAL if the script isn't cached by the server
+func (s *Script) Do(ctx context.Context, conn redigo.Conn, keysAndArgs ...interface{}) *Result {
+	args := s.args(s.hash, keysAndArgs)
+
+	cmd := "EVALSHA"
+	reply, err := redigo.DoContext(conn, ctx, cmd, args...)
+	if isNoScript(err) {
+		cmd, args[0] = "EVAL", s.src
+		reply, err = redigo.DoContext(conn, ctx, cmd, args...)
+	}
+
+	return &Result{cmd: cmd, args: args, reply: reply, err: err}
+}
+
+// Load caches the script on the server
+func (s *Script) Load(ctx context.Context, conn redigo.Conn) error {
+	if _, err := redigo.DoContext(conn, ctx, "SCRIPT", "LOAD", s.src); err != nil {
+		return errors.Wrapf(err, "failed to load script %s", s.hash)
+	}
+
+	return nil
+}
+
+func (s *Script) args(spec string, keysAndArgs []interface{}) []interface{} {
+	args := make([]interface{}, 0, len(keysAndArgs)+2)
+	args = append(args, spec)
+	if s.keyCount >= 0 {
+		args = append(args, s.keyCount)
+	}
+
+	return append(args, keysAndArgs...)
+}
+
+// LoadScripts caches all registered scripts on the server in a single round trip
+func LoadScripts(ctx context.Context, conn redigo.Conn) error {
+	registryMu.Lock()
+	scripts := append([]*Script(nil), registry...)
+	registryMu.Unlock()
+
+	for _, script := range scripts {
+		if err := conn.Send("SCRIPT", "LOAD", script.src); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	for _, script := range scripts {
+		if _, err := redigo.ReceiveContext(conn, ctx); err != nil {
+			return errors.Wrapf(err, "failed to load script %s", script.hash)
+		}
+	}
+
+	return nil
+}
+
+func isNoScript(err error) bool {
+	var redisErr redigo.Error
+	return errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOSCRIPT")
+}
@@ -0,0 +1,394 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/config"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	roleMaster        = "master"
+	roleReplica       = "slave"
+	switchMasterEvent = "+switch-master"
+	roleCheckInterval = time.Second
+	rewatchInterval   = time.Second
+)
+
+// ErrNoSentinel is returned when none of the sentinels knows the master
+var ErrNoSentinel = errors.New("no sentinel is available")
+
+// sentinel discovers the master and replicas of cfg.SentinelMasterName() and follows failovers
+type sentinel struct {
+	cfg       config.RedisConfig
+	mu        sync.RWMutex
+	addresses []string
+	master    string
+	// generation is bumped whenever the master moves to another address,
+	// connections of older generations are dropped by the pool on borrow
+	generation uint64
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+func newSentinel(cfg config.RedisConfig) *sentinel {
+	return &sentinel{
+		cfg:       cfg,
+		addresses: append([]string(nil), cfg.SentinelAddresses()...),
+	}
+}
+
+// masterPool returns a pool of connections to the current master
+func (s *sentinel) masterPool() *redigo.Pool {
+	pool := NewPool(s.cfg)
+	pool.DialContext = func(ctx context.Context) (redigo.Conn, error) {
+		generation := s.currentGeneration()
+
+		address, err := s.masterAddress(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := s.dialRole(ctx, address, roleMaster)
+		if err != nil {
+			// the cached master may be stale, it's replaced only if the sentinels know another one
+			if _, discoverErr := s.discoverMaster(ctx); discoverErr != nil {
+				log.Printf("failed to rediscover redis master: %v\n", discoverErr)
+			}
+			return nil, err
+		}
+		conn.generation = generation
+
+		return conn, nil
+	}
+	// the role check replaces the ping installed by NewPool
+	pool.TestOnBorrowContext = func(ctx context.Context, c redigo.Conn, lastUsed time.Time) error {
+		if conn, ok := c.(*addressedConn); ok && (conn.generation != s.currentGeneration() || conn.address != s.currentMaster()) {
+			return errors.Errorf("redis master has moved from %s", conn.address)
+		}
+
+		if time.Since(lastUsed) < s.checkInterval() {
+			return nil
+		}
+
+		return checkRole(ctx, c, roleMaster)
+	}
+
+	return pool
+}
+
+// replicaPool returns a pool of connections to random replicas, it falls back to the master when there are no replicas
+func (s *sentinel) replicaPool() *redigo.Pool {
+	pool := NewPool(s.cfg)
+	pool.DialContext = func(ctx context.Context) (redigo.Conn, error) {
+		address, role := "", roleReplica
+
+		replicas, err := s.replicaAddresses(ctx)
+		if err != nil || len(replicas) == 0 {
+			if address, err = s.masterAddress(ctx); err != nil {
+				return nil, err
+			}
+			role = roleMaster
+		} else {
+			address = replicas[rand.Intn(len(replicas))]
+		}
+
+		conn, err := s.dialRole(ctx, address, role)
+		if err != nil {
+			return nil, err
+		}
+
+		return conn, nil
+	}
+	pool.TestOnBorrowContext = func(ctx context.Context, c redigo.Conn, lastUsed time.Time) error {
+		if time.Since(lastUsed) < s.checkInterval() {
+			return nil
+		}
+
+		_, err := redigo.DoContext(c, ctx, "PING")
+
+		return err
+	}
+
+	return pool
+}
+
+// checkInterval returns how long a connection may be idle before it's checked on borrow,
+// cfg.TestOnBorrowInterval() overrides the default role check interval
+func (s *sentinel) checkInterval() time.Duration {
+	if interval := s.cfg.TestOnBorrowInterval(); interval > 0 {
+		return interval
+	}
+
+	return roleCheckInterval
+}
+
+func (s *sentinel) dialRole(ctx context.Context, address, role string) (*addressedConn, error) {
+	conn, err := dial(ctx, s.cfg, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkRole(ctx, conn, role); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &addressedConn{Conn: conn, address: address}, nil
+}
+
+func (s *sentinel) currentMaster() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.master
+}
+
+func (s *sentinel) currentGeneration() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.generation
+}
+
+// setMaster caches the master address, connections to the previous one are drained
+func (s *sentinel) setMaster(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if address == s.master {
+		return
+	}
+
+	if s.master != "" {
+		s.generation++
+	}
+
+	log.Printf("redis master %q is at %s\n", s.cfg.SentinelMasterName(), address)
+	s.master = address
+}
+
+// masterAddress returns the known master address or discovers it
+func (s *sentinel) masterAddress(ctx context.Context) (string, error) {
+	if address := s.currentMaster(); address != "" {
+		return address, nil
+	}
+
+	return s.discoverMaster(ctx)
+}
+
+// discoverMaster asks the sentinels for the master address and caches it
+func (s *sentinel) discoverMaster(ctx context.Context) (string, error) {
+	var address string
+	err := s.query(ctx, func(conn redigo.Conn) error {
+		reply, err := redigo.Strings(redigo.DoContext(conn, ctx, "SENTINEL", "get-master-addr-by-name", s.cfg.SentinelMasterName()))
+		if err != nil {
+			return err
+		}
+
+		if len(reply) != 2 {
+			return errors.Errorf("unexpected master address reply %v", reply)
+		}
+
+		address = net.JoinHostPort(reply[0], reply[1])
+
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to discover redis master %q", s.cfg.SentinelMasterName())
+	}
+
+	s.setMaster(address)
+
+	return address, nil
+}
+
+// replicaAddresses returns addresses of healthy replicas
+func (s *sentinel) replicaAddresses(ctx context.Context) ([]string, error) {
+	var addresses []string
+	err := s.query(ctx, func(conn redigo.Conn) error {
+		replicas, err := redigo.Values(redigo.DoContext(conn, ctx, "SENTINEL", "replicas", s.cfg.SentinelMasterName()))
+		if err != nil {
+			return err
+		}
+
+		for _, replica := range replicas {
+			fields, err := redigo.StringMap(replica, nil)
+			if err != nil {
+				return err
+			}
+
+			flags := fields["flags"]
+			if strings.Contains(flags, "s_down") || strings.Contains(flags, "o_down") || strings.Contains(flags, "disconnected") {
+				continue
+			}
+
+			addresses = append(addresses, net.JoinHostPort(fields["ip"], fields["port"]))
+		}
+
+		return nil
+	})
+
+	return addresses, err
+}
+
+// query runs fn on the first available sentinel and moves it to the front of the list
+func (s *sentinel) query(ctx context.Context, fn func(conn redigo.Conn) error) error {
+	s.mu.RLock()
+	addresses := append([]string(nil), s.addresses...)
+	s.mu.RUnlock()
+
+	lastErr := ErrNoSentinel
+	for i, address := range addresses {
+		conn, err := redigo.DialContext(ctx, "tcp", address, append(sentinelDialOptions(s.cfg), redigo.DialReadTimeout(s.cfg.ReadTimeout()))...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		err = fn(conn)
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if i > 0 {
+			s.mu.Lock()
+			s.addresses = append([]string{address}, append(addresses[:i:i], addresses[i+1:]...)...)
+			s.mu.Unlock()
+		}
+
+		return nil
+	}
+
+	return lastErr
+}
+
+// watch follows +switch-master events until close
+func (s *sentinel) watch() {
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		for {
+			if err := s.listen(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("failed to watch redis sentinels: %v\n", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(rewatchInterval):
+			}
+		}
+	}()
+}
+
+func (s *sentinel) listen(ctx context.Context) error {
+	s.mu.RLock()
+	address := s.addresses[0]
+	s.mu.RUnlock()
+
+	// the subscription waits for events as long as needed, so there's no read timeout
+	conn, err := redigo.DialContext(ctx, "tcp", address, sentinelDialOptions(s.cfg)...)
+	if err != nil {
+		// rotate the sentinels so that the next attempt uses another one
+		s.mu.Lock()
+		s.addresses = append(s.addresses[1:], s.addresses[0])
+		s.mu.Unlock()
+
+		return err
+	}
+
+	psc := redigo.PubSubConn{Conn: conn}
+	defer psc.Close()
+
+	if err = psc.Subscribe(switchMasterEvent); err != nil {
+		return err
+	}
+
+	for {
+		switch v := psc.ReceiveContext(ctx).(type) {
+		case redigo.Subscription:
+			// failovers may have happened while we were not subscribed, the master changes only if it has moved
+			if _, err = s.discoverMaster(ctx); err != nil {
+				log.Printf("failed to rediscover redis master: %v\n", err)
+			}
+		case redigo.Message:
+			// <master name> <old ip> <old port> <new ip> <new port>
+			parts := strings.Fields(string(v.Data))
+			if len(parts) == 5 && parts[0] == s.cfg.SentinelMasterName() {
+				s.setMaster(net.JoinHostPort(parts[3], parts[4]))
+			}
+		case error:
+			return v
+		}
+	}
+}
+
+func (s *sentinel) close() {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+}
+
+func checkRole(ctx context.Context, conn redigo.Conn, role string) error {
+	reply, err := redigo.Values(redigo.DoContext(conn, ctx, "ROLE"))
+	if err != nil {
+		return err
+	}
+
+	if len(reply) == 0 {
+		return errors.New("empty role reply")
+	}
+
+	actual, err := redigo.String(reply[0], nil)
+	if err != nil {
+		return err
+	}
+
+	if actual != role {
+		return errors.Errorf("redis role is %q instead of %q", actual, role)
+	}
+
+	return nil
+}
+
+// addressedConn remembers the address and the master generation the connection was dialed to
+type addressedConn struct {
+	redigo.Conn
+	address    string
+	generation uint64
+}
+
+// DoContext sends a command to the server and returns the received reply
+func (c *addressedConn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	return redigo.DoContext(c.Conn, ctx, cmd, args...)
+}
+
+// DoWithTimeout sends a command to the server and returns the received reply
+func (c *addressedConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return redigo.DoWithTimeout(c.Conn, timeout, cmd, args...)
+}
+
+// ReceiveContext receives a single reply from the server
+func (c *addressedConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return redigo.ReceiveContext(c.Conn, ctx)
+}
+
+// ReceiveWithTimeout receives a single reply from the server
+func (c *addressedConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redigo.ReceiveWithTimeout(c.Conn, timeout)
+}
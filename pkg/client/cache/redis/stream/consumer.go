@@ -0,0 +1,363 @@
+package stream
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	cacheredis "github.com/andredubov/golibs/pkg/client/cache/redis"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBlock         = 5 * time.Second
+	defaultCount         = 10
+	defaultClaimMinIdle  = time.Minute
+	defaultClaimInterval = 30 * time.Second
+	retryInterval        = time.Second
+	blockReadMargin      = time.Second
+)
+
+// ErrConsumerStopped is returned by Run when the consumer has been stopped
+var ErrConsumerStopped = errors.New("stream consumer is stopped")
+
+// ConsumerConfig configures a consumer of a group
+type ConsumerConfig struct {
+	Stream   string
+	Group    string
+	Consumer string
+	// Block is the longest time XREADGROUP waits for new messages
+	Block time.Duration
+	// Count is the maximum number of messages read or claimed at once
+	Count int
+	// ClaimMinIdle is the time after which pending messages of crashed consumers are reclaimed
+	ClaimMinIdle time.Duration
+	// ClaimInterval is the period of reclaiming pending messages
+	ClaimInterval time.Duration
+	// MaxDeliveries moves a message to DeadLetterStream once it has been delivered more times (zero means never)
+	MaxDeliveries int64
+	// DeadLetterStream receives messages exceeding MaxDeliveries along with _stream, _id, _group
+	// and _deliveries fields, it must share the cluster slot of the stream, defaults to "{<stream>}:dead"
+	// or to "<stream>:dead" if the stream name already has a hash tag
+	DeadLetterStream string
+}
+
+// Consumer interface for reading a stream within a consumer group
+type Consumer interface {
+	// Run processes messages until ctx is done or Stop is called
+	Run(ctx context.Context) error
+	// Stop interrupts Run and waits for the in-flight messages to be handled, it fits closer.Add
+	Stop() error
+}
+
+// deadLetterScript adds the message to the dead letter stream and acknowledges it, a failed XADD aborts
+// the script before XACK, so the message stays pending instead of being lost.
+// KEYS[1] is the dead letter stream, KEYS[2] is the stream, ARGV[1] is the group, ARGV[2] is the message id, ARGV[3..] are fields
+var deadLetterScript = cacheredis.NewScript(2, `
+local id = redis.call("XADD", KEYS[1], "*", unpack(ARGV, 3))
+redis.call("XACK", KEYS[2], ARGV[1], ARGV[2])
+return id
+`)
+
+type consumer struct {
+	connectionPool *redigo.Pool
+	config         ConsumerConfig
+	handler        Handler
+	mu             sync.Mutex
+	stopped        bool
+	cancel         context.CancelFunc
+	done           chan struct{}
+}
+
+// NewConsumer returns a new consumer handling messages of cfg.Stream in cfg.Group
+func NewConsumer(connectionPool *redigo.Pool, cfg ConsumerConfig, handler Handler) (Consumer, error) {
+	if cfg.Stream == "" || cfg.Group == "" || cfg.Consumer == "" {
+		return nil, errors.New("stream, group and consumer names are required")
+	}
+
+	if cfg.Block <= 0 {
+		cfg.Block = defaultBlock
+	}
+	if cfg.Count <= 0 {
+		cfg.Count = defaultCount
+	}
+	if cfg.ClaimMinIdle <= 0 {
+		cfg.ClaimMinIdle = defaultClaimMinIdle
+	}
+	if cfg.ClaimInterval <= 0 {
+		cfg.ClaimInterval = defaultClaimInterval
+	}
+	if cfg.DeadLetterStream == "" {
+		cfg.DeadLetterStream = deadLetterStream(cfg.Stream)
+	}
+
+	return &consumer{
+		connectionPool: connectionPool,
+		config:         cfg,
+		handler:        handler,
+	}, nil
+}
+
+// Run creates the group if needed and processes new and reclaimed messages
+func (c *consumer) Run(ctx context.Context) error {
+	c.mu.Lock()
+	if c.stopped || c.done != nil {
+		c.mu.Unlock()
+		return ErrConsumerStopped
+	}
+
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	c.mu.Unlock()
+
+	defer close(c.done)
+
+	if err := c.createGroup(ctx); err != nil {
+		return err
+	}
+
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= c.config.ClaimInterval {
+			if err := c.reclaim(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("failed to reclaim pending messages of stream %q: %v\n", c.config.Stream, err)
+			}
+			lastClaim = time.Now()
+		}
+
+		messages, err := c.read(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+
+			log.Printf("failed to read stream %q: %v\n", c.config.Stream, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryInterval):
+			}
+
+			continue
+		}
+
+		c.handle(ctx, messages)
+	}
+
+	return nil
+}
+
+// Stop interrupts Run and waits for it to return
+func (c *consumer) Stop() error {
+	c.mu.Lock()
+	c.stopped = true
+	cancel, done := c.cancel, c.done
+	c.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	return nil
+}
+
+func (c *consumer) createGroup(ctx context.Context) error {
+	err := c.do(ctx, func(conn redigo.Conn) error {
+		_, err := redigo.DoContext(conn, ctx, "XGROUP", "CREATE", c.config.Stream, c.config.Group, "$", "MKSTREAM")
+		return err
+	})
+	if err != nil && !isBusyGroup(err) {
+		return errors.Wrapf(err, "failed to create group %q of stream %q", c.config.Group, c.config.Stream)
+	}
+
+	return nil
+}
+
+func (c *consumer) read(ctx context.Context) ([]Message, error) {
+	var messages []Message
+	err := c.do(ctx, func(conn redigo.Conn) error {
+		// the read waits up to Block, it's bounded by its own timeout instead of the read timeout of the pool
+		reply, err := redigo.DoWithTimeout(conn, c.config.Block+blockReadMargin, "XREADGROUP",
+			"GROUP", c.config.Group, c.config.Consumer,
+			"COUNT", c.config.Count,
+			"BLOCK", c.config.Block.Milliseconds(),
+			"STREAMS", c.config.Stream, ">",
+		)
+		if err != nil || reply == nil {
+			return err
+		}
+
+		streams, err := redigo.Values(reply, nil)
+		if err != nil {
+			return err
+		}
+
+		for _, stream := range streams {
+			parts, err := redigo.Values(stream, nil)
+			if err != nil || len(parts) != 2 {
+				return errors.Errorf("unexpected XREADGROUP reply: %v", err)
+			}
+
+			entries, err := parseEntries(c.config.Stream, parts[1])
+			if err != nil {
+				return err
+			}
+
+			for i := range entries {
+				entries[i].Deliveries = 1
+			}
+			messages = append(messages, entries...)
+		}
+
+		return nil
+	})
+
+	return messages, err
+}
+
+// reclaim takes over messages pending longer than ClaimMinIdle and dead-letters the ones delivered too many times
+func (c *consumer) reclaim(ctx context.Context) error {
+	cursor := "0-0"
+	for {
+		var messages []Message
+		err := c.do(ctx, func(conn redigo.Conn) error {
+			reply, err := redigo.Values(redigo.DoContext(conn, ctx, "XAUTOCLAIM",
+				c.config.Stream, c.config.Group, c.config.Consumer,
+				c.config.ClaimMinIdle.Milliseconds(), cursor, "COUNT", c.config.Count,
+			))
+			if err != nil {
+				return err
+			}
+
+			if len(reply) < 2 {
+				return errors.New("unexpected XAUTOCLAIM reply")
+			}
+
+			if cursor, err = redigo.String(reply[0], nil); err != nil {
+				return err
+			}
+
+			if messages, err = parseEntries(c.config.Stream, reply[1]); err != nil {
+				return err
+			}
+
+			return c.fillDeliveries(ctx, conn, messages)
+		})
+		if err != nil {
+			return err
+		}
+
+		live := messages[:0]
+		for _, msg := range messages {
+			if c.config.MaxDeliveries > 0 && msg.Deliveries > c.config.MaxDeliveries {
+				if err = c.deadLetter(ctx, msg); err != nil {
+					log.Printf("failed to dead-letter message %s of stream %q: %v\n", msg.ID, c.config.Stream, err)
+				}
+				continue
+			}
+			live = append(live, msg)
+		}
+
+		c.handle(ctx, live)
+
+		if cursor == "0-0" || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// fillDeliveries sets delivery counters of the claimed messages from XPENDING
+func (c *consumer) fillDeliveries(ctx context.Context, conn redigo.Conn, messages []Message) error {
+	for _, msg := range messages {
+		if err := conn.Send("XPENDING", c.config.Stream, c.config.Group, msg.ID, msg.ID, 1); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		reply, err := redigo.Values(redigo.ReceiveContext(conn, ctx))
+		if err != nil {
+			return err
+		}
+
+		if len(reply) == 0 {
+			continue
+		}
+
+		parts, err := redigo.Values(reply[0], nil)
+		if err != nil || len(parts) != 4 {
+			return errors.Errorf("unexpected XPENDING reply: %v", err)
+		}
+
+		if messages[i].Deliveries, err = redigo.Int64(parts[3], nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *consumer) handle(ctx context.Context, messages []Message) {
+	for _, msg := range messages {
+		// in-flight messages are finished even if the consumer is being stopped
+		if err := c.handler(context.WithoutCancel(ctx), msg); err != nil {
+			log.Printf("failed to handle message %s of stream %q: %v\n", msg.ID, c.config.Stream, err)
+			continue
+		}
+
+		if err := c.ack(context.WithoutCancel(ctx), msg.ID); err != nil {
+			log.Printf("failed to ack message %s of stream %q: %v\n", msg.ID, c.config.Stream, err)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (c *consumer) ack(ctx context.Context, id string) error {
+	return c.do(ctx, func(conn redigo.Conn) error {
+		_, err := redigo.DoContext(conn, ctx, "XACK", c.config.Stream, c.config.Group, id)
+		return err
+	})
+}
+
+// deadLetterStream names the dead letter stream by the hash tag of the stream, so that the script touching both
+// isn't rejected as CROSSSLOT by a cluster, a name without a tag is hashed as a whole like its tagged copy
+func deadLetterStream(stream string) string {
+	if start := strings.IndexByte(stream, '{'); start >= 0 {
+		if end := strings.IndexByte(stream[start+1:], '}'); end > 0 {
+			return stream + ":dead"
+		}
+	}
+
+	return "{" + stream + "}:dead"
+}
+
+// deadLetter moves the message to the dead letter stream atomically
+func (c *consumer) deadLetter(ctx context.Context, msg Message) error {
+	return c.do(ctx, func(conn redigo.Conn) error {
+		args := redigo.Args{c.config.DeadLetterStream, c.config.Stream, c.config.Group, msg.ID}.
+			Add("_stream", msg.Stream, "_id", msg.ID, "_group", c.config.Group, "_deliveries", msg.Deliveries).
+			AddFlat(msg.Values)
+
+		return deadLetterScript.Do(ctx, conn, args...).Err()
+	})
+}
+
+func (c *consumer) do(ctx context.Context, fn func(conn redigo.Conn) error) error {
+	conn, err := c.connectionPool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return fn(conn)
+}
@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"context"
+	"strings"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// Message is an entry of a redis stream
+type Message struct {
+	ID     string
+	Stream string
+	Values map[string]string
+	// Deliveries is the number of times the message has been delivered to consumers of the group
+	Deliveries int64
+}
+
+// Handler processes a message, the message is acknowledged when nil is returned
+// and redelivered after the consumer's claim idle time otherwise
+type Handler func(ctx context.Context, msg Message) error
+
+// Producer interface for adding messages to streams
+type Producer interface {
+	Add(ctx context.Context, stream string, values map[string]interface{}) (string, error)
+}
+
+type producer struct {
+	connectionPool *redigo.Pool
+	maxLen         int64
+}
+
+// NewProducer returns a new stream producer, streams are approximately trimmed to maxLen entries (zero means unbounded)
+func NewProducer(connectionPool *redigo.Pool, maxLen int64) Producer {
+	return &producer{
+		connectionPool: connectionPool,
+		maxLen:         maxLen,
+	}
+}
+
+// Add appends a message to the stream and returns its id
+func (p *producer) Add(ctx context.Context, stream string, values map[string]interface{}) (string, error) {
+	if len(values) == 0 {
+		return "", errors.New("message has no values")
+	}
+
+	conn, err := p.connectionPool.GetContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	args := redigo.Args{stream}
+	if p.maxLen > 0 {
+		args = args.Add("MAXLEN", "~", p.maxLen)
+	}
+	args = args.Add("*").AddFlat(values)
+
+	id, err := redigo.String(redigo.DoContext(conn, ctx, "XADD", args...))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to add message to stream %q", stream)
+	}
+
+	return id, nil
+}
+
+// parseEntries parses [[id, [field, value, ...]], ...] stream reply, deleted entries have nil values
+func parseEntries(stream string, reply interface{}) ([]Message, error) {
+	entries, err := redigo.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(entries))
+	for _, entry := range entries {
+		if entry == nil {
+			continue
+		}
+
+		parts, err := redigo.Values(entry, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(parts) != 2 {
+			return nil, errors.Errorf("unexpected stream entry length %d", len(parts))
+		}
+
+		id, err := redigo.String(parts[0], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var values map[string]string
+		if parts[1] != nil {
+			if values, err = redigo.StringMap(parts[1], nil); err != nil {
+				return nil, err
+			}
+		}
+
+		messages = append(messages, Message{ID: id, Stream: stream, Values: values})
+	}
+
+	return messages, nil
+}
+
+func isBusyGroup(err error) bool {
+	var redisErr redigo.Error
+	return errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "BUSYGROUP")
+}
@@ -0,0 +1,222 @@
+package tag
+
+import (
+	"context"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	"github.com/andredubov/golibs/pkg/client/cache/redis"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPrefix = "tag:"
+	batchSize     = 500
+)
+
+// attachScript adds the key to the tag sets and removes a sample of expired keys from them,
+// so a tag set shrinks as its keys expire and is removed by redis once it's empty.
+// KEYS are the tag sets, ARGV[1] is the key, ARGV[2] is the sample size
+var attachScript = redis.NewScript(-1, `
+for i = 1, #KEYS do
+	redis.call("SADD", KEYS[i], ARGV[1])
+
+	for _, key in ipairs(redis.call("SRANDMEMBER", KEYS[i], tonumber(ARGV[2]))) do
+		if redis.call("EXISTS", key) == 0 then
+			redis.call("SREM", KEYS[i], key)
+		end
+	end
+end
+
+return 1
+`)
+
+// invalidateScript atomically deletes all keys of the tag sets and the sets themselves,
+// returns the number of deleted keys and the keys of the sets.
+// KEYS are the tag sets
+var invalidateScript = redis.NewScript(-1, `
+local deleted = 0
+local tagged = {}
+for i = 1, #KEYS do
+	local keys = redis.call("SMEMBERS", KEYS[i])
+	for j = 1, #keys, 500 do
+		deleted = deleted + redis.call("UNLINK", unpack(keys, j, math.min(j + 499, #keys)))
+	end
+
+	for _, key in ipairs(keys) do
+		table.insert(tagged, key)
+	end
+	redis.call("UNLINK", KEYS[i])
+end
+
+return {deleted, tagged}
+`)
+
+// pruneScript removes expired and deleted keys from the tag set, returns the number of removed keys.
+// KEYS[1] is the tag set
+var pruneScript = redis.NewScript(1, `
+local removed = 0
+for _, key in ipairs(redis.call("SMEMBERS", KEYS[1])) do
+	if redis.call("EXISTS", key) == 0 then
+		removed = removed + redis.call("SREM", KEYS[1], key)
+	end
+end
+
+return removed
+`)
+
+// attachPruneSample is the number of tag set members checked for expiration on every attach
+const attachPruneSample = 10
+
+// Tagged is a cache attaching tags to keys so that all keys of a tag are invalidated at once,
+// e.g. all views derived from an entity are tagged by the entity id. Tag sets keep keys as is,
+// so the decorated cache must not rename them (compression and near cache are fine, namespace isn't)
+type Tagged interface {
+	cache.Cache
+	// SetWithTags binds the key and its value and attaches tags to the key, zero ttl means no expiration
+	SetWithTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags ...string) error
+	// InvalidateTag atomically deletes all keys tagged by any of the tags, returns the number of deleted keys
+	InvalidateTag(ctx context.Context, tags ...string) (int64, error)
+	// Keys returns keys attached to the tag, expired keys are listed until they are pruned
+	Keys(ctx context.Context, tag string) ([]string, error)
+	// Prune removes all expired and deleted keys from the tag set, returns the number of removed keys,
+	// it's optional since expired keys are also pruned gradually by SetWithTags
+	Prune(ctx context.Context, tag string) (int64, error)
+}
+
+// Option configures a tagged cache
+type Option func(t *tagged)
+
+// WithPrefix sets the prefix of tag set keys, "tag:" by default
+func WithPrefix(prefix string) Option {
+	return func(t *tagged) {
+		t.prefix = prefix
+	}
+}
+
+type tagged struct {
+	cache.Cache
+	client redis.Client
+	prefix string
+}
+
+// New returns a tagged decorator of the cache, tag sets are kept in redis served by the client
+func New(c cache.Cache, client redis.Client, opts ...Option) Tagged {
+	t := &tagged{
+		Cache:  c,
+		client: client,
+		prefix: defaultPrefix,
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// SetWithTags binds the key and its value and attaches tags to the key
+func (t *tagged) SetWithTags(ctx context.Context, key string, value interface{}, ttl time.Duration, tags ...string) error {
+	if ttl < 0 {
+		return errors.Wrap(cache.ErrInvalidSetOptions, "negative ttl")
+	}
+
+	if _, _, err := t.SetWithOptions(ctx, key, value, cache.SetOptions{TTL: ttl}); err != nil {
+		return errors.Wrapf(err, "failed to set tagged key %q", key)
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	// the value is written first, so an invalidation racing with the write either deletes it or finds it tagged
+	args := append(t.tagKeys(tags), key, attachPruneSample)
+	if err := t.client.RunScript(ctx, attachScript, args...).Err(); err != nil {
+		return errors.Wrapf(t.rollback(ctx, key, err), "failed to tag key %q by %v", key, tags)
+	}
+
+	return nil
+}
+
+// InvalidateTag atomically deletes all keys tagged by any of the tags
+func (t *tagged) InvalidateTag(ctx context.Context, tags ...string) (int64, error) {
+	if len(tags) == 0 {
+		return 0, nil
+	}
+
+	reply, err := t.client.RunScript(ctx, invalidateScript, t.tagKeys(tags)...).Values()
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to invalidate tags %v", tags)
+	}
+
+	if len(reply) != 2 {
+		return 0, errors.Errorf("unexpected reply of tags %v invalidation: %v", tags, reply)
+	}
+
+	deleted, err := redigo.Int64(reply[0], nil)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unexpected reply of tags %v invalidation", tags)
+	}
+
+	keys, err := redigo.Strings(reply[1], nil)
+	if err != nil {
+		return deleted, errors.Wrapf(err, "unexpected reply of tags %v invalidation", tags)
+	}
+
+	// the keys are already deleted, the decorated cache is told about it to drop its copies (e.g. near cache)
+	for start := 0; start < len(keys); start += batchSize {
+		if err = t.MDelete(ctx, keys[start:min(start+batchSize, len(keys))]...); err != nil {
+			return deleted, errors.Wrapf(err, "failed to propagate invalidation of tags %v", tags)
+		}
+	}
+
+	return deleted, nil
+}
+
+// Keys returns keys attached to the tag
+func (t *tagged) Keys(ctx context.Context, tag string) ([]string, error) {
+	pipeline := t.client.Pipeline()
+	members := pipeline.Queue("SMEMBERS", t.prefix+tag)
+
+	if err := pipeline.Exec(ctx); err != nil {
+		return nil, errors.Wrapf(err, "failed to get keys of tag %q", tag)
+	}
+
+	keys, err := members.Strings()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get keys of tag %q", tag)
+	}
+
+	return keys, nil
+}
+
+// Prune removes expired and deleted keys from the tag set
+func (t *tagged) Prune(ctx context.Context, tag string) (int64, error) {
+	removed, err := t.client.RunScript(ctx, pruneScript, t.prefix+tag).Int64()
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to prune tag %q", tag)
+	}
+
+	return removed, nil
+}
+
+// rollback deletes the key which failed to be tagged, so it can't outlive an invalidation of its tags
+func (t *tagged) rollback(ctx context.Context, key string, err error) error {
+	if deleteErr := t.Delete(ctx, key); deleteErr != nil {
+		return errors.Wrapf(err, "failed to delete untagged key: %v", deleteErr)
+	}
+
+	return err
+}
+
+// tagKeys returns the number of tag sets followed by their keys as expected by scripts with variable key count
+func (t *tagged) tagKeys(tags []string) []interface{} {
+	keys := make([]interface{}, 0, len(tags)+1)
+	keys = append(keys, len(tags))
+	for _, tag := range tags {
+		keys = append(keys, t.prefix+tag)
+	}
+
+	return keys
+}
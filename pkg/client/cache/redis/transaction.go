@@ -0,0 +1,184 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// ErrTxAborted is returned when watched keys kept changing during all the attempts of a transaction
+var ErrTxAborted = errors.New("transaction aborted: watched keys have been changed")
+
+// TxHandler reads current values and queues writes of the transaction, it may be called several times
+type TxHandler func(ctx context.Context, tx Tx) error
+
+// Tx is an optimistic redis transaction
+type Tx interface {
+	// Do runs a read command immediately on the connection holding the watch
+	Do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error)
+	// Get returns value by its key
+	Get(ctx context.Context, key string) (interface{}, error)
+	// Queue adds the command to MULTI/EXEC block, its result is available after commit
+	Queue(cmd string, args ...interface{}) *Result
+}
+
+// TxManager executes handlers in WATCH-based optimistic transactions
+type TxManager interface {
+	// Watch watches keys, calls fn and commits the queued commands with MULTI/EXEC, if any of the keys
+	// has been changed the transaction is retried up to the configured limit
+	Watch(ctx context.Context, fn TxHandler, keys ...string) error
+}
+
+type txManager struct {
+	connectionPool *redigo.Pool
+	maxRetries     int
+	commandTimeout time.Duration
+}
+
+// NewTxManager returns a new transaction manager retrying aborted transactions up to maxRetries times,
+// commandTimeout limits every command of a transaction unless its ctx has a deadline
+func NewTxManager(connectionPool *redigo.Pool, maxRetries int, commandTimeout time.Duration) TxManager {
+	return &txManager{
+		connectionPool: connectionPool,
+		maxRetries:     maxRetries,
+		commandTimeout: commandTimeout,
+	}
+}
+
+// Watch executes fn in an optimistic transaction
+func (m *txManager) Watch(ctx context.Context, fn TxHandler, keys ...string) error {
+	if len(keys) == 0 {
+		return errors.New("no keys to watch")
+	}
+
+	conn, err := m.getConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for attempt := 0; attempt <= m.maxRetries; attempt++ {
+		committed, err := m.transaction(ctx, conn, fn, keys)
+		if err != nil {
+			return err
+		}
+
+		if committed {
+			return nil
+		}
+
+		if err = ctx.Err(); err != nil {
+			return contextError(ctx, err)
+		}
+	}
+
+	return ErrTxAborted
+}
+
+// transaction runs a single attempt, reports whether EXEC has been committed
+func (m *txManager) transaction(ctx context.Context, conn redigo.Conn, fn TxHandler, keys []string) (committed bool, err error) {
+	if _, err = doCommand(ctx, conn, m.commandTimeout, "WATCH", redigo.Args{}.AddFlat(keys)...); err != nil {
+		return false, errors.Wrap(err, "can't watch keys")
+	}
+
+	tx := &tx{conn: conn, commandTimeout: m.commandTimeout}
+	defer func() {
+		// recovering after panic
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic recovered: %v", r)
+		}
+
+		// releasing the watch if the transaction hasn't reached EXEC
+		if err != nil || len(tx.results) == 0 {
+			if _, errUnwatch := doCommand(ctx, conn, m.commandTimeout, "UNWATCH"); errUnwatch != nil && err == nil {
+				err = errors.Wrap(errUnwatch, "can't unwatch keys")
+			}
+		}
+	}()
+
+	if err = fn(ctx, tx); err != nil {
+		return false, errors.Wrap(err, "failed executing code inside transaction")
+	}
+
+	if len(tx.results) == 0 {
+		return true, nil
+	}
+
+	if err = conn.Send("MULTI"); err != nil {
+		return false, contextError(ctx, err)
+	}
+
+	for _, result := range tx.results {
+		if err = conn.Send(result.cmd, result.args...); err != nil {
+			return false, contextError(ctx, err)
+		}
+	}
+
+	// EXEC flushes MULTI and the queued commands, so the timeout covers all of them
+	replies, err := redigo.Values(doCommand(ctx, conn, m.commandTimeout, "EXEC"))
+	if errors.Is(err, redigo.ErrNil) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errors.Wrap(err, "tx exec failed")
+	}
+
+	for i, result := range tx.results {
+		result.reply, result.err = replies[i], nil
+		if redisErr, ok := replies[i].(redigo.Error); ok {
+			result.reply, result.err = nil, replyError(redisErr)
+		}
+	}
+
+	return true, nil
+}
+
+// getConn takes a connection limited by the command timeout
+func (m *txManager) getConn(ctx context.Context) (redigo.Conn, error) {
+	ctx, cancel := withCommandTimeout(ctx, m.commandTimeout)
+	defer cancel()
+
+	conn, err := m.connectionPool.GetContext(ctx)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	return conn, nil
+}
+
+// doCommand runs the command with the command timeout and the error mapping of the cache
+func doCommand(ctx context.Context, conn redigo.Conn, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	ctx, cancel := withCommandTimeout(ctx, timeout)
+	defer cancel()
+
+	reply, err := redigo.DoContext(conn, ctx, cmd, args...)
+
+	return reply, contextError(ctx, replyError(err))
+}
+
+type tx struct {
+	conn           redigo.Conn
+	commandTimeout time.Duration
+	results        []*Result
+}
+
+// Do runs a read command immediately
+func (t *tx) Do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	return doCommand(ctx, t.conn, t.commandTimeout, cmd, args...)
+}
+
+// Get returns value by its key
+func (t *tx) Get(ctx context.Context, key string) (interface{}, error) {
+	return t.Do(ctx, "GET", key)
+}
+
+// Queue adds the command to MULTI/EXEC block
+func (t *tx) Queue(cmd string, args ...interface{}) *Result {
+	result := &Result{cmd: cmd, args: args, err: ErrPipelineNotExecuted}
+	t.results = append(t.results, result)
+
+	return result
+}
@@ -0,0 +1,142 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+// State of the circuit breaker
+type State int
+
+const (
+	// StateClosed lets all commands through
+	StateClosed State = iota
+	// StateOpen fails all commands fast until the open timeout elapses
+	StateOpen
+	// StateHalfOpen lets a single probe through to check whether the server recovered
+	StateHalfOpen
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// breaker opens after failureThreshold consecutive failures
+type breaker struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	onStateChange    func(from, to State)
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether a command may be sent
+func (b *breaker) allow() bool {
+	if b.failureThreshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.current() {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+
+	return true
+}
+
+// record accounts the outcome of an allowed command
+func (b *breaker) record(failed bool) {
+	if b.failureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.current()
+	if state == StateHalfOpen {
+		b.probing = false
+	}
+
+	if !failed {
+		b.failures = 0
+		b.setState(StateClosed)
+		return
+	}
+
+	b.failures++
+	if state == StateHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = time.Now()
+		b.setState(StateOpen)
+	}
+}
+
+// abandon releases the probe of the half-open breaker without accounting the outcome,
+// e.g. when the command was canceled by the caller
+func (b *breaker) abandon() {
+	if b.failureThreshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.current() == StateHalfOpen {
+		b.probing = false
+	}
+}
+
+// currentState returns the state for health checks
+func (b *breaker) currentState() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.current()
+}
+
+// current moves the open breaker to half-open once the open timeout elapses, b.mu must be held
+func (b *breaker) current() State {
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.openTimeout {
+		b.setState(StateHalfOpen)
+	}
+
+	return b.state
+}
+
+func (b *breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+
+	from := b.state
+	b.state = state
+	if state != StateHalfOpen {
+		b.probing = false
+	}
+
+	if b.onStateChange != nil {
+		go b.onStateChange(from, state)
+	}
+}
@@ -0,0 +1,443 @@
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxRetries       = 2
+	defaultInitialBackoff   = 20 * time.Millisecond
+	defaultMaxBackoff       = 500 * time.Millisecond
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 5 * time.Second
+)
+
+// ErrCircuitOpen is returned without sending the command while the circuit breaker is open
+var ErrCircuitOpen = errors.New("cache circuit breaker is open")
+
+// Resilient is a cache retrying idempotent commands on transient errors and
+// failing fast while the server is down
+type Resilient interface {
+	cache.Cache
+	// State returns the state of the circuit breaker, suitable for health checks
+	State() State
+}
+
+// Option configures a resilient cache
+type Option func(o *options)
+
+type options struct {
+	maxRetries       int
+	initialBackoff   time.Duration
+	maxBackoff       time.Duration
+	failureThreshold int
+	openTimeout      time.Duration
+	onStateChange    func(from, to State)
+	isFailure        func(err error) bool
+}
+
+// WithRetry retries idempotent commands up to maxRetries times sleeping a random
+// duration up to initialBackoff*2^attempt capped by maxBackoff between attempts
+func WithRetry(maxRetries int, initialBackoff, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		o.maxRetries = maxRetries
+		if initialBackoff > 0 {
+			o.initialBackoff = initialBackoff
+		}
+		if maxBackoff > 0 {
+			o.maxBackoff = maxBackoff
+		}
+	}
+}
+
+// WithBreaker opens the circuit after failureThreshold consecutive failures for openTimeout,
+// zero threshold disables the breaker
+func WithBreaker(failureThreshold int, openTimeout time.Duration) Option {
+	return func(o *options) {
+		o.failureThreshold = failureThreshold
+		if openTimeout > 0 {
+			o.openTimeout = openTimeout
+		}
+	}
+}
+
+// WithStateChange calls fn asynchronously on every transition of the circuit breaker
+func WithStateChange(fn func(from, to State)) Option {
+	return func(o *options) {
+		o.onStateChange = fn
+	}
+}
+
+// WithFailureClassifier overrides which errors are transient, they are retried and counted by the breaker
+func WithFailureClassifier(isFailure func(err error) bool) Option {
+	return func(o *options) {
+		o.isFailure = isFailure
+	}
+}
+
+type resilient struct {
+	cache   cache.Cache
+	breaker *breaker
+	options options
+}
+
+// New returns a new resilient decorator of the cache
+func New(c cache.Cache, opts ...Option) Resilient {
+	o := options{
+		maxRetries:       defaultMaxRetries,
+		initialBackoff:   defaultInitialBackoff,
+		maxBackoff:       defaultMaxBackoff,
+		failureThreshold: defaultFailureThreshold,
+		openTimeout:      defaultOpenTimeout,
+		isFailure:        IsTransient,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &resilient{
+		cache: c,
+		breaker: &breaker{
+			failureThreshold: o.failureThreshold,
+			openTimeout:      o.openTimeout,
+			onStateChange:    o.onStateChange,
+		},
+		options: o,
+	}
+}
+
+// IsTransient reports whether the error is caused by the connection, an exhausted pool, a timeout
+// or a temporarily unavailable server, any other error is considered permanent
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) ||
+		errors.Is(err, redigo.ErrPoolExhausted) || errors.Is(err, cache.ErrTimeout) {
+		return true
+	}
+
+	var redisErr redigo.Error
+	if errors.As(err, &redisErr) {
+		message := string(redisErr)
+		return strings.HasPrefix(message, "LOADING") || strings.HasPrefix(message, "TRYAGAIN") ||
+			strings.HasPrefix(message, "CLUSTERDOWN") || strings.HasPrefix(message, "BUSY") ||
+			strings.HasPrefix(message, "MASTERDOWN")
+	}
+
+	return false
+}
+
+// State returns the state of the circuit breaker
+func (r *resilient) State() State {
+	return r.breaker.currentState()
+}
+
+// Set binds a key and a value into cache
+func (r *resilient) Set(ctx context.Context, key string, value interface{}) error {
+	return r.exec(ctx, true, func(ctx context.Context) error {
+		return r.cache.Set(ctx, key, value)
+	})
+}
+
+// SetWithOptions isn't retried since its outcome depends on the current value of the key
+func (r *resilient) SetWithOptions(ctx context.Context, key string, value interface{}, opts cache.SetOptions) (bool, interface{}, error) {
+	var (
+		written  bool
+		previous interface{}
+	)
+
+	err := r.exec(ctx, false, func(ctx context.Context) (err error) {
+		written, previous, err = r.cache.SetWithOptions(ctx, key, value, opts)
+		return err
+	})
+
+	return written, previous, err
+}
+
+// Get returns a value by the key from cache
+func (r *resilient) Get(ctx context.Context, key string) (interface{}, error) {
+	var value interface{}
+
+	err := r.exec(ctx, true, func(ctx context.Context) (err error) {
+		value, err = r.cache.Get(ctx, key)
+		return err
+	})
+
+	return value, err
+}
+
+// MSet binds keys and their values into cache
+func (r *resilient) MSet(ctx context.Context, values map[string]interface{}) error {
+	return r.exec(ctx, true, func(ctx context.Context) error {
+		return r.cache.MSet(ctx, values)
+	})
+}
+
+// MGet returns values by their keys from cache
+func (r *resilient) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	var values []interface{}
+
+	err := r.exec(ctx, true, func(ctx context.Context) (err error) {
+		values, err = r.cache.MGet(ctx, keys...)
+		return err
+	})
+
+	return values, err
+}
+
+// MDelete deletes values by their keys into cache
+func (r *resilient) MDelete(ctx context.Context, keys ...string) error {
+	return r.exec(ctx, true, func(ctx context.Context) error {
+		return r.cache.MDelete(ctx, keys...)
+	})
+}
+
+// HashSet binds hash fields and their values into cache
+func (r *resilient) HashSet(ctx context.Context, key string, values interface{}) error {
+	return r.exec(ctx, true, func(ctx context.Context) error {
+		return r.cache.HashSet(ctx, key, values)
+	})
+}
+
+// HashGetAll returns all fields and values of the hash
+func (r *resilient) HashGetAll(ctx context.Context, key string) ([]interface{}, error) {
+	var values []interface{}
+
+	err := r.exec(ctx, true, func(ctx context.Context) (err error) {
+		values, err = r.cache.HashGetAll(ctx, key)
+		return err
+	})
+
+	return values, err
+}
+
+// HashGet returns the value of the hash field
+func (r *resilient) HashGet(ctx context.Context, key string, field string) (interface{}, error) {
+	var value interface{}
+
+	err := r.exec(ctx, true, func(ctx context.Context) (err error) {
+		value, err = r.cache.HashGet(ctx, key, field)
+		return err
+	})
+
+	return value, err
+}
+
+// HashMGet returns values of the hash fields
+func (r *resilient) HashMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error) {
+	var values []interface{}
+
+	err := r.exec(ctx, true, func(ctx context.Context) (err error) {
+		values, err = r.cache.HashMGet(ctx, key, fields...)
+		return err
+	})
+
+	return values, err
+}
+
+// HashDel isn't retried since its result depends on whether the fields existed
+func (r *resilient) HashDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	var deleted int64
+
+	err := r.exec(ctx, false, func(ctx context.Context) (err error) {
+		deleted, err = r.cache.HashDel(ctx, key, fields...)
+		return err
+	})
+
+	return deleted, err
+}
+
+// HashIncrBy isn't retried since a lost reply of an applied increment would double it
+func (r *resilient) HashIncrBy(ctx context.Context, key string, field string, increment int64) (int64, error) {
+	var value int64
+
+	err := r.exec(ctx, false, func(ctx context.Context) (err error) {
+		value, err = r.cache.HashIncrBy(ctx, key, field, increment)
+		return err
+	})
+
+	return value, err
+}
+
+// HashExists reports whether the hash field exists
+func (r *resilient) HashExists(ctx context.Context, key string, field string) (bool, error) {
+	var ok bool
+
+	err := r.exec(ctx, true, func(ctx context.Context) (err error) {
+		ok, err = r.cache.HashExists(ctx, key, field)
+		return err
+	})
+
+	return ok, err
+}
+
+// HashScanStruct reads the hash into the struct pointed by dest
+func (r *resilient) HashScanStruct(ctx context.Context, key string, dest interface{}) error {
+	return r.exec(ctx, true, func(ctx context.Context) error {
+		return r.cache.HashScanStruct(ctx, key, dest)
+	})
+}
+
+// HashExpire isn't retried since its result depends on whether the fields existed
+func (r *resilient) HashExpire(ctx context.Context, key string, expiration time.Duration, fields ...string) ([]bool, error) {
+	var result []bool
+
+	err := r.exec(ctx, false, func(ctx context.Context) (err error) {
+		result, err = r.cache.HashExpire(ctx, key, expiration, fields...)
+		return err
+	})
+
+	return result, err
+}
+
+// HashTTL returns remaining time to live of the hash fields
+func (r *resilient) HashTTL(ctx context.Context, key string, fields ...string) ([]time.Duration, error) {
+	var ttls []time.Duration
+
+	err := r.exec(ctx, true, func(ctx context.Context) (err error) {
+		ttls, err = r.cache.HashTTL(ctx, key, fields...)
+		return err
+	})
+
+	return ttls, err
+}
+
+// Expire sets expiration of the key
+func (r *resilient) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	return r.exec(ctx, true, func(ctx context.Context) error {
+		return r.cache.Expire(ctx, key, expiration)
+	})
+}
+
+// PExpire isn't retried since its result depends on whether the key existed
+func (r *resilient) PExpire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	var ok bool
+
+	err := r.exec(ctx, false, func(ctx context.Context) (err error) {
+		ok, err = r.cache.PExpire(ctx, key, expiration)
+		return err
+	})
+
+	return ok, err
+}
+
+// TTL returns remaining time to live of the key
+func (r *resilient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+
+	err := r.exec(ctx, true, func(ctx context.Context) (err error) {
+		ttl, err = r.cache.TTL(ctx, key)
+		return err
+	})
+
+	return ttl, err
+}
+
+// Persist isn't retried since its result depends on whether the key had expiration
+func (r *resilient) Persist(ctx context.Context, key string) (bool, error) {
+	var ok bool
+
+	err := r.exec(ctx, false, func(ctx context.Context) (err error) {
+		ok, err = r.cache.Persist(ctx, key)
+		return err
+	})
+
+	return ok, err
+}
+
+// Delete deletes a value by the key from cache
+func (r *resilient) Delete(ctx context.Context, key string) error {
+	return r.exec(ctx, true, func(ctx context.Context) error {
+		return r.cache.Delete(ctx, key)
+	})
+}
+
+// Ping tests connection to the cache, it's sent even if the breaker is open so
+// health checks may close the breaker as soon as the server recovers
+func (r *resilient) Ping(ctx context.Context) error {
+	err := r.cache.Ping(ctx)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+
+	r.breaker.record(err != nil && r.options.isFailure(err))
+
+	return err
+}
+
+// Close closes the underlying cache
+func (r *resilient) Close() error {
+	return r.cache.Close()
+}
+
+// exec runs the command through the breaker, idempotent commands are retried on transient errors
+func (r *resilient) exec(ctx context.Context, idempotent bool, command func(ctx context.Context) error) error {
+	attempts := 1
+	if idempotent {
+		attempts += r.options.maxRetries
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if waitErr := r.sleep(ctx, attempt); waitErr != nil {
+				return err
+			}
+		}
+
+		if !r.breaker.allow() {
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrCircuitOpen, err)
+			}
+			return ErrCircuitOpen
+		}
+
+		err = command(ctx)
+		if err != nil && ctx.Err() != nil {
+			r.breaker.abandon()
+			return err
+		}
+
+		failed := err != nil && r.options.isFailure(err)
+		r.breaker.record(failed)
+
+		if !failed {
+			return err
+		}
+	}
+
+	return err
+}
+
+// sleep waits a random backoff with full jitter before the attempt
+func (r *resilient) sleep(ctx context.Context, attempt int) error {
+	backoff := r.options.maxBackoff
+	if shift := attempt - 1; shift < 32 {
+		if exp := r.options.initialBackoff << shift; exp > 0 && exp < backoff {
+			backoff = exp
+		}
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff) + 1)))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
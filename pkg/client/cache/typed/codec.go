@@ -0,0 +1,115 @@
+package typed
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotBinary is returned by the binary codec when a value doesn't implement encoding.BinaryMarshaler/BinaryUnmarshaler
+var ErrNotBinary = errors.New("value doesn't implement binary marshaling")
+
+// Codec converts values to bytes stored into cache and back
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, value interface{}) error
+}
+
+type jsonCodec struct{}
+
+// JSON returns a codec encoding values as JSON documents
+func JSON() Codec {
+	return jsonCodec{}
+}
+
+// Marshal encodes value as JSON
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Unmarshal decodes JSON data into value
+func (jsonCodec) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+type gobCodec struct{}
+
+// Gob returns a codec encoding values with encoding/gob
+func Gob() Codec {
+	return gobCodec{}
+}
+
+// Marshal encodes value with gob
+func (gobCodec) Marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes gob data into value
+func (gobCodec) Unmarshal(data []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+type binaryCodec struct{}
+
+// Binary returns a codec for compact binary formats (msgpack, protobuf, etc.), it supports a type T
+// (or *T) whose T or *T implements encoding.BinaryMarshaler and *T implements encoding.BinaryUnmarshaler,
+// so both value and pointer receivers work and Typed[T] as well as Typed[*T] may be used
+func Binary() Codec {
+	return binaryCodec{}
+}
+
+// Marshal encodes value with its MarshalBinary method, falling back to the method of the value's address
+func (binaryCodec) Marshal(value interface{}) ([]byte, error) {
+	if marshaler, ok := value.(encoding.BinaryMarshaler); ok {
+		return marshaler.MarshalBinary()
+	}
+
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return nil, errors.Wrapf(ErrNotBinary, "%T", value)
+	}
+
+	// the method may have a pointer receiver while the value is passed by value
+	addressable := reflect.New(v.Type())
+	addressable.Elem().Set(v)
+	if marshaler, ok := addressable.Interface().(encoding.BinaryMarshaler); ok {
+		return marshaler.MarshalBinary()
+	}
+
+	return nil, errors.Wrapf(ErrNotBinary, "%T", value)
+}
+
+// Unmarshal decodes data with UnmarshalBinary method of value, if value points to a nil pointer
+// (e.g. **T of Typed[*T]) the element is allocated first
+func (binaryCodec) Unmarshal(data []byte, value interface{}) error {
+	if unmarshaler, ok := value.(encoding.BinaryUnmarshaler); ok {
+		return unmarshaler.UnmarshalBinary(data)
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Pointer {
+		return errors.Wrapf(ErrNotBinary, "%T", value)
+	}
+
+	element := reflect.New(v.Elem().Type().Elem())
+	unmarshaler, ok := element.Interface().(encoding.BinaryUnmarshaler)
+	if !ok {
+		return errors.Wrapf(ErrNotBinary, "%T", value)
+	}
+
+	if err := unmarshaler.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	v.Elem().Set(element)
+
+	return nil
+}
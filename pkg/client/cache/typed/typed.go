@@ -0,0 +1,104 @@
+package typed
+
+import (
+	"context"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/client/cache"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned when the key is absent in cache, it's an alias of cache.ErrNotFound
+var ErrNotFound = cache.ErrNotFound
+
+// Typed is a cache storing values of type T encoded by a codec
+type Typed[T any] interface {
+	Set(ctx context.Context, key string, value T) error
+	SetWithTTL(ctx context.Context, key string, value T, ttl time.Duration) error
+	Get(ctx context.Context, key string) (T, error)
+	Expire(ctx context.Context, key string, expiration time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+type typedCache[T any] struct {
+	cache cache.Cache
+	codec Codec
+}
+
+// New returns a new instance of typed cache built on top of any cache.Cache
+func New[T any](cache cache.Cache, codec Codec) Typed[T] {
+	return &typedCache[T]{
+		cache: cache,
+		codec: codec,
+	}
+}
+
+// Set encodes value and binds it to the key
+func (t *typedCache[T]) Set(ctx context.Context, key string, value T) error {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode value of key %q", key)
+	}
+
+	return t.cache.Set(ctx, key, data)
+}
+
+// SetWithTTL encodes value and binds it to the key expiring after ttl atomically
+func (t *typedCache[T]) SetWithTTL(ctx context.Context, key string, value T, ttl time.Duration) error {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode value of key %q", key)
+	}
+
+	_, _, err = t.cache.SetWithOptions(ctx, key, data, cache.SetOptions{TTL: ttl})
+
+	return err
+}
+
+// Get returns decoded value by its key from cache
+func (t *typedCache[T]) Get(ctx context.Context, key string) (T, error) {
+	var value T
+
+	raw, err := t.cache.Get(ctx, key)
+	if err != nil {
+		return value, err
+	}
+
+	data, err := toBytes(raw)
+	if err != nil {
+		return value, errors.Wrapf(err, "failed to read value of key %q", key)
+	}
+
+	if data == nil {
+		return value, ErrNotFound
+	}
+
+	if err = t.codec.Unmarshal(data, &value); err != nil {
+		return value, errors.Wrapf(err, "failed to decode value of key %q", key)
+	}
+
+	return value, nil
+}
+
+// Expire sets time to expire key into cache
+func (t *typedCache[T]) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	return t.cache.Expire(ctx, key, expiration)
+}
+
+// Delete a value by its key into cache
+func (t *typedCache[T]) Delete(ctx context.Context, key string) error {
+	return t.cache.Delete(ctx, key)
+}
+
+func toBytes(raw interface{}) ([]byte, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, errors.Errorf("unexpected type %T for cached value", raw)
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"flag"
 	"time"
@@ -48,7 +49,26 @@ type RedisConfig interface {
 	Address() string
 	ConnectionTimeout() time.Duration
 	MaxIdle() int
+	MaxActive() int
+	Wait() bool
 	IdleTimeout() time.Duration
+	MaxConnLifetime() time.Duration
+	TestOnBorrowInterval() time.Duration
+	SentinelAddresses() []string
+	SentinelMasterName() string
+	SentinelUsername() string
+	SentinelPassword() string
+	SentinelTLSConfig() *tls.Config
+	ReadFromReplicas() bool
+	ClusterAddresses() []string
+	Username() string
+	Password() string
+	DB() int
+	TLSConfig() *tls.Config
+	DialTimeout() time.Duration
+	ReadTimeout() time.Duration
+	WriteTimeout() time.Duration
+	CommandTimeout() time.Duration
 }
 
 // AuthConfing interface
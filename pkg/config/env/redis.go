@@ -1,9 +1,12 @@
 package env
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/andredubov/golibs/pkg/config"
@@ -11,30 +14,84 @@ import (
 )
 
 const (
-	redisHostEnvName              = "RD_HOST"
-	redisPortEnvName              = "RD_PORT"
-	redisConnectionTimeoutEnvName = "RD_CONNECTION_TIMEOUT_SEC"
-	redisMaxIdleEnvName           = "RD_MAX_IDLE"
-	redisMaxIdleTimeoutEnvName    = "RD_MAX_IDLE_TIMEOUT_SEC"
+	redisHostEnvName               = "RD_HOST"
+	redisPortEnvName               = "RD_PORT"
+	redisConnectionTimeoutEnvName  = "RD_CONNECTION_TIMEOUT_SEC"
+	redisMaxIdleEnvName            = "RD_MAX_IDLE"
+	redisMaxIdleTimeoutEnvName     = "RD_MAX_IDLE_TIMEOUT_SEC"
+	redisMaxActiveEnvName          = "RD_MAX_ACTIVE"
+	redisWaitEnvName               = "RD_WAIT"
+	redisMaxConnLifetimeEnvName    = "RD_MAX_CONN_LIFETIME_SEC"
+	redisTestOnBorrowEnvName       = "RD_TEST_ON_BORROW_INTERVAL_SEC"
+	redisSentinelAddressesEnvName  = "RD_SENTINEL_ADDRESSES"
+	redisSentinelMasterNameEnvName = "RD_SENTINEL_MASTER_NAME"
+	redisSentinelUsernameEnvName   = "RD_SENTINEL_USERNAME"
+	redisSentinelPasswordEnvName   = "RD_SENTINEL_PASSWORD"
+	redisSentinelTLSEnabledEnvName = "RD_SENTINEL_TLS_ENABLED"
+	redisReadFromReplicasEnvName   = "RD_READ_FROM_REPLICAS"
+	redisClusterAddressesEnvName   = "RD_CLUSTER_ADDRESSES"
+	redisUsernameEnvName           = "RD_USERNAME"
+	redisPasswordEnvName           = "RD_PASSWORD"
+	redisDBEnvName                 = "RD_DB"
+	redisTLSEnabledEnvName         = "RD_TLS_ENABLED"
+	redisTLSCACertEnvName          = "RD_TLS_CA_CERT_PATH"
+	redisTLSCertEnvName            = "RD_TLS_CERT_PATH"
+	redisTLSKeyEnvName             = "RD_TLS_KEY_PATH"
+	redisTLSServerNameEnvName      = "RD_TLS_SERVER_NAME"
+	redisTLSSkipVerifyEnvName      = "RD_TLS_INSECURE_SKIP_VERIFY"
+	redisDialTimeoutEnvName        = "RD_DIAL_TIMEOUT_SEC"
+	redisReadTimeoutEnvName        = "RD_READ_TIMEOUT_SEC"
+	redisWriteTimeoutEnvName       = "RD_WRITE_TIMEOUT_SEC"
+	redisCommandTimeoutEnvName     = "RD_COMMAND_TIMEOUT_MS"
 )
 
 type redisConfig struct {
-	host              string
-	port              string
-	connectionTimeout time.Duration
-	maxIdle           int
-	maxIdleTimeout    time.Duration
+	host               string
+	port               string
+	connectionTimeout  time.Duration
+	maxIdle            int
+	maxIdleTimeout     time.Duration
+	maxActive          int
+	wait               bool
+	maxConnLifetime    time.Duration
+	testOnBorrow       time.Duration
+	sentinelAddresses  []string
+	sentinelMasterName string
+	sentinelUsername   string
+	sentinelPassword   string
+	sentinelTLSConfig  *tls.Config
+	readFromReplicas   bool
+	clusterAddresses   []string
+	username           string
+	password           string
+	db                 int
+	tlsConfig          *tls.Config
+	dialTimeout        time.Duration
+	readTimeout        time.Duration
+	writeTimeout       time.Duration
+	commandTimeout     time.Duration
 }
 
 // NewRedisConfig returns a new instance of redisConfig struct
 func NewRedisConfig() (config.RedisConfig, error) {
+	sentinelAddresses := splitAddresses(os.Getenv(redisSentinelAddressesEnvName))
+	clusterAddresses := splitAddresses(os.Getenv(redisClusterAddressesEnvName))
+
+	sentinelMasterName := os.Getenv(redisSentinelMasterNameEnvName)
+	if len(sentinelAddresses) != 0 && len(sentinelMasterName) == 0 {
+		return nil, errors.New("redis sentinel master name not found")
+	}
+
+	// the address is discovered through sentinels or cluster nodes if they are specified
+	discovered := len(sentinelAddresses) != 0 || len(clusterAddresses) != 0
+
 	host := os.Getenv(redisHostEnvName)
-	if len(host) == 0 {
+	if len(host) == 0 && !discovered {
 		return nil, errors.New("redis host not found")
 	}
 
 	port := os.Getenv(redisPortEnvName)
-	if len(port) == 0 {
+	if len(port) == 0 && !discovered {
 		return nil, errors.New("redis port not found")
 	}
 
@@ -68,15 +125,180 @@ func NewRedisConfig() (config.RedisConfig, error) {
 		return nil, errors.Wrap(err, "failed to parse idle timeout")
 	}
 
+	var maxActive int
+	if maxActiveStr := os.Getenv(redisMaxActiveEnvName); len(maxActiveStr) != 0 {
+		maxActive, err = strconv.Atoi(maxActiveStr)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse max active")
+		}
+	}
+
+	var wait bool
+	if waitStr := os.Getenv(redisWaitEnvName); len(waitStr) != 0 {
+		wait, err = strconv.ParseBool(waitStr)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse wait")
+		}
+	}
+
+	maxConnLifetime, err := optionalSeconds(redisMaxConnLifetimeEnvName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse max connection lifetime")
+	}
+
+	testOnBorrow, err := optionalSeconds(redisTestOnBorrowEnvName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse test on borrow interval")
+	}
+
+	var readFromReplicas bool
+	if readFromReplicasStr := os.Getenv(redisReadFromReplicasEnvName); len(readFromReplicasStr) != 0 {
+		readFromReplicas, err = strconv.ParseBool(readFromReplicasStr)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse read from replicas")
+		}
+	}
+
+	var db int
+	if dbStr := os.Getenv(redisDBEnvName); len(dbStr) != 0 {
+		db, err = strconv.Atoi(dbStr)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse database index")
+		}
+	}
+
+	tlsConfig, err := newRedisTLSConfig(redisTLSEnabledEnvName)
+	if err != nil {
+		return nil, err
+	}
+
+	// sentinels share certificate settings with redis, but TLS and credentials are enabled separately
+	sentinelTLSConfig, err := newRedisTLSConfig(redisSentinelTLSEnabledEnvName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to configure sentinel tls")
+	}
+
+	dialTimeout, err := optionalSeconds(redisDialTimeoutEnvName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse dial timeout")
+	}
+
+	readTimeout, err := optionalSeconds(redisReadTimeoutEnvName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse read timeout")
+	}
+
+	writeTimeout, err := optionalSeconds(redisWriteTimeoutEnvName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse write timeout")
+	}
+
+	commandTimeout, err := optionalDuration(redisCommandTimeoutEnvName, time.Millisecond)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse command timeout")
+	}
+
 	return &redisConfig{
-		host:              host,
-		port:              port,
-		connectionTimeout: time.Duration(connectionTimeout) * time.Second,
-		maxIdle:           maxIdle,
-		maxIdleTimeout:    time.Duration(maxIdleTimeout) * time.Second,
+		host:               host,
+		port:               port,
+		connectionTimeout:  time.Duration(connectionTimeout) * time.Second,
+		maxIdle:            maxIdle,
+		maxIdleTimeout:     time.Duration(maxIdleTimeout) * time.Second,
+		maxActive:          maxActive,
+		wait:               wait,
+		maxConnLifetime:    maxConnLifetime,
+		testOnBorrow:       testOnBorrow,
+		sentinelAddresses:  sentinelAddresses,
+		sentinelMasterName: sentinelMasterName,
+		sentinelUsername:   os.Getenv(redisSentinelUsernameEnvName),
+		sentinelPassword:   os.Getenv(redisSentinelPasswordEnvName),
+		sentinelTLSConfig:  sentinelTLSConfig,
+		readFromReplicas:   readFromReplicas,
+		clusterAddresses:   clusterAddresses,
+		username:           os.Getenv(redisUsernameEnvName),
+		password:           os.Getenv(redisPasswordEnvName),
+		db:                 db,
+		tlsConfig:          tlsConfig,
+		dialTimeout:        dialTimeout,
+		readTimeout:        readTimeout,
+		writeTimeout:       writeTimeout,
+		commandTimeout:     commandTimeout,
 	}, nil
 }
 
+// newRedisTLSConfig returns tls config if TLS is enabled by the environment variable, nil otherwise
+func newRedisTLSConfig(enabledEnvName string) (*tls.Config, error) {
+	enabledStr := os.Getenv(enabledEnvName)
+	if len(enabledStr) == 0 {
+		return nil, nil
+	}
+
+	enabled, err := strconv.ParseBool(enabledStr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse tls enabled")
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: os.Getenv(redisTLSServerNameEnvName),
+	}
+
+	if skipVerifyStr := os.Getenv(redisTLSSkipVerifyEnvName); len(skipVerifyStr) != 0 {
+		tlsConfig.InsecureSkipVerify, err = strconv.ParseBool(skipVerifyStr)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse tls insecure skip verify")
+		}
+	}
+
+	if caCertPath := os.Getenv(redisTLSCACertEnvName); len(caCertPath) != 0 {
+		caCert, err := os.ReadFile(caCertPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read tls ca certificate")
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("failed to parse tls ca certificate")
+		}
+	}
+
+	certPath, keyPath := os.Getenv(redisTLSCertEnvName), os.Getenv(redisTLSKeyEnvName)
+	if len(certPath) != 0 || len(keyPath) != 0 {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load tls client certificate")
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// optionalSeconds parses duration in seconds from the environment variable, zero if it's not set
+func optionalSeconds(name string) (time.Duration, error) {
+	return optionalDuration(name, time.Second)
+}
+
+// optionalDuration parses duration in units from the environment variable, zero if it's not set
+func optionalDuration(name string, unit time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	count, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(count) * unit, nil
+}
+
 func (cfg *redisConfig) Address() string {
 	return net.JoinHostPort(cfg.host, cfg.port)
 }
@@ -92,3 +314,91 @@ func (cfg *redisConfig) MaxIdle() int {
 func (cfg *redisConfig) IdleTimeout() time.Duration {
 	return cfg.maxIdleTimeout
 }
+
+func (cfg *redisConfig) MaxActive() int {
+	return cfg.maxActive
+}
+
+func (cfg *redisConfig) Wait() bool {
+	return cfg.wait
+}
+
+func (cfg *redisConfig) MaxConnLifetime() time.Duration {
+	return cfg.maxConnLifetime
+}
+
+func (cfg *redisConfig) TestOnBorrowInterval() time.Duration {
+	return cfg.testOnBorrow
+}
+
+func (cfg *redisConfig) SentinelAddresses() []string {
+	return cfg.sentinelAddresses
+}
+
+func (cfg *redisConfig) SentinelMasterName() string {
+	return cfg.sentinelMasterName
+}
+
+func (cfg *redisConfig) SentinelUsername() string {
+	return cfg.sentinelUsername
+}
+
+func (cfg *redisConfig) SentinelPassword() string {
+	return cfg.sentinelPassword
+}
+
+func (cfg *redisConfig) SentinelTLSConfig() *tls.Config {
+	return cfg.sentinelTLSConfig
+}
+
+func (cfg *redisConfig) ReadFromReplicas() bool {
+	return cfg.readFromReplicas
+}
+
+func (cfg *redisConfig) ClusterAddresses() []string {
+	return cfg.clusterAddresses
+}
+
+func (cfg *redisConfig) Username() string {
+	return cfg.username
+}
+
+func (cfg *redisConfig) Password() string {
+	return cfg.password
+}
+
+func (cfg *redisConfig) DB() int {
+	return cfg.db
+}
+
+func (cfg *redisConfig) TLSConfig() *tls.Config {
+	return cfg.tlsConfig
+}
+
+func (cfg *redisConfig) DialTimeout() time.Duration {
+	return cfg.dialTimeout
+}
+
+func (cfg *redisConfig) ReadTimeout() time.Duration {
+	return cfg.readTimeout
+}
+
+func (cfg *redisConfig) WriteTimeout() time.Duration {
+	return cfg.writeTimeout
+}
+
+func (cfg *redisConfig) CommandTimeout() time.Duration {
+	return cfg.commandTimeout
+}
+
+// splitAddresses parses comma separated list of addresses
+func splitAddresses(value string) []string {
+	var addresses []string
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); len(address) != 0 {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/andredubov/golibs/pkg/ratelimit"
+	"github.com/pkg/errors"
+)
+
+var errLimitNotSet = errors.New("rate limit is not set")
+
+type gcra struct {
+	mu  sync.Mutex
+	tat map[string]time.Time
+	now func() time.Time
+}
+
+// NewGCRA returns an in-memory limiter implementing generic cell rate algorithm, it's intended for tests
+func NewGCRA() ratelimit.Limiter {
+	return &gcra{
+		tat: make(map[string]time.Time),
+		now: time.Now,
+	}
+}
+
+// Allow reports whether a request may happen now
+func (g *gcra) Allow(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return g.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN reports whether n requests may happen now
+func (g *gcra) AllowN(_ context.Context, key string, limit ratelimit.Limit, n int) (ratelimit.Result, error) {
+	if limit.IsZero() {
+		return ratelimit.Result{}, errLimitNotSet
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	emissionInterval := limit.Period / time.Duration(limit.Rate)
+	burstOffset := emissionInterval * time.Duration(limit.MaxBurst())
+
+	tat, ok := g.tat[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(emissionInterval * time.Duration(n))
+	diff := now.Sub(newTat.Add(-burstOffset))
+	if diff < 0 {
+		return ratelimit.Result{
+			RetryAfter: -diff,
+			ResetAfter: tat.Sub(now),
+		}, nil
+	}
+
+	g.tat[key] = newTat
+
+	return ratelimit.Result{
+		Allowed:    true,
+		Remaining:  int(diff / emissionInterval),
+		ResetAfter: newTat.Sub(now),
+	}, nil
+}
+
+// Reset clears the state of the key
+func (g *gcra) Reset(_ context.Context, key string) error {
+	g.mu.Lock()
+	delete(g.tat, key)
+	g.mu.Unlock()
+
+	return nil
+}
+
+type slidingWindow struct {
+	mu  sync.Mutex
+	log map[string][]time.Time
+	now func() time.Time
+}
+
+// NewSlidingWindow returns an in-memory limiter implementing sliding window log algorithm, it's intended for tests
+func NewSlidingWindow() ratelimit.Limiter {
+	return &slidingWindow{
+		log: make(map[string][]time.Time),
+		now: time.Now,
+	}
+}
+
+// Allow reports whether a request may happen now
+func (s *slidingWindow) Allow(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return s.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN reports whether n requests may happen now
+func (s *slidingWindow) AllowN(_ context.Context, key string, limit ratelimit.Limit, n int) (ratelimit.Result, error) {
+	if limit.IsZero() {
+		return ratelimit.Result{}, errLimitNotSet
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	requests := s.log[key]
+
+	// drop requests that left the window, the log is sorted by time
+	start := 0
+	for start < len(requests) && !requests[start].After(now.Add(-limit.Period)) {
+		start++
+	}
+	requests = requests[start:]
+
+	var resetAfter time.Duration
+	if len(requests) > 0 {
+		resetAfter = requests[len(requests)-1].Add(limit.Period).Sub(now)
+	}
+
+	if len(requests)+n > limit.Rate {
+		s.log[key] = requests
+
+		retryAfter := limit.Period
+		if n <= limit.Rate {
+			retryAfter = requests[len(requests)+n-limit.Rate-1].Add(limit.Period).Sub(now)
+		}
+
+		return ratelimit.Result{
+			Remaining:  limit.Rate - len(requests),
+			RetryAfter: retryAfter,
+			ResetAfter: resetAfter,
+		}, nil
+	}
+
+	for i := 0; i < n; i++ {
+		requests = append(requests, now)
+	}
+	s.log[key] = requests
+
+	return ratelimit.Result{
+		Allowed:    true,
+		Remaining:  limit.Rate - len(requests),
+		ResetAfter: limit.Period,
+	}, nil
+}
+
+// Reset clears the log of the key
+func (s *slidingWindow) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.log, key)
+	s.mu.Unlock()
+
+	return nil
+}
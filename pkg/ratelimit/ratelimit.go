@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit is the number of requests allowed per period
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	// Burst is the number of requests allowed at once by GCRA, defaults to Rate
+	Burst int
+}
+
+// PerSecond returns a limit of rate requests per second
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second, Burst: rate}
+}
+
+// PerMinute returns a limit of rate requests per minute
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute, Burst: rate}
+}
+
+// PerHour returns a limit of rate requests per hour
+func PerHour(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Hour, Burst: rate}
+}
+
+// IsZero reports whether the limit is not set
+func (l Limit) IsZero() bool {
+	return l.Rate <= 0 || l.Period <= 0
+}
+
+// MaxBurst returns the burst size, Rate if Burst is not set
+func (l Limit) MaxBurst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+
+	return l.Rate
+}
+
+// Result is an outcome of a rate limited request
+type Result struct {
+	Allowed bool
+	// Remaining is the number of requests that may be made right now
+	Remaining int
+	// RetryAfter is the time after which the denied request may be retried
+	RetryAfter time.Duration
+	// ResetAfter is the time after which the limiter returns to its initial state
+	ResetAfter time.Duration
+}
+
+// Limiter interface for rate limiting requests per key
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	AllowN(ctx context.Context, key string, limit Limit, n int) (Result, error)
+	Reset(ctx context.Context, key string) error
+}
@@ -0,0 +1,157 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	cacheredis "github.com/andredubov/golibs/pkg/client/cache/redis"
+	"github.com/andredubov/golibs/pkg/ratelimit"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// gcraScript implements generic cell rate algorithm storing theoretical arrival time of the key,
+// time values are passed as strings since lua numbers are truncated to integers in replies
+var gcraScript = cacheredis.NewScript(1, `
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local emission_interval = period / rate
+local increment = emission_interval * cost
+local burst_offset = emission_interval * burst
+
+local time = redis.call("TIME")
+local now = (time[1] - 1483228800) + (time[2] / 1000000)
+
+local tat = redis.call("GET", KEYS[1])
+if not tat then
+	tat = now
+else
+	tat = tonumber(tat)
+end
+tat = math.max(tat, now)
+
+local new_tat = tat + increment
+local diff = now - (new_tat - burst_offset)
+local remaining = diff / emission_interval
+
+if remaining < 0 then
+	return {0, 0, tostring(-diff), tostring(tat - now)}
+end
+
+local reset_after = new_tat - now
+if reset_after > 0 then
+	redis.call("SET", KEYS[1], tostring(new_tat), "EX", math.ceil(reset_after))
+end
+
+return {1, math.floor(remaining), "0", tostring(reset_after)}
+`)
+
+type gcra struct {
+	connectionPool *redigo.Pool
+	prefix         string
+}
+
+// NewGCRA returns a limiter implementing generic cell rate algorithm, keys are prefixed with prefix
+func NewGCRA(connectionPool *redigo.Pool, prefix string) ratelimit.Limiter {
+	return &gcra{
+		connectionPool: connectionPool,
+		prefix:         prefix,
+	}
+}
+
+// Allow reports whether a request may happen now
+func (g *gcra) Allow(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return g.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN reports whether n requests may happen now
+func (g *gcra) AllowN(ctx context.Context, key string, limit ratelimit.Limit, n int) (ratelimit.Result, error) {
+	if limit.IsZero() {
+		return ratelimit.Result{}, errors.New("rate limit is not set")
+	}
+
+	var reply []interface{}
+	err := do(ctx, g.connectionPool, func(conn redigo.Conn) error {
+		var errEx error
+		reply, errEx = gcraScript.Do(ctx, conn, g.prefix+key,
+			limit.MaxBurst(), limit.Rate, limit.Period.Seconds(), n,
+		).Values()
+
+		return errEx
+	})
+	if err != nil {
+		return ratelimit.Result{}, errors.Wrapf(err, "failed to limit key %q", key)
+	}
+
+	return parseResult(reply)
+}
+
+// Reset clears the state of the key
+func (g *gcra) Reset(ctx context.Context, key string) error {
+	return do(ctx, g.connectionPool, func(conn redigo.Conn) error {
+		_, err := redigo.DoContext(conn, ctx, "DEL", g.prefix+key)
+		return err
+	})
+}
+
+// parseResult parses {allowed, remaining, retry after, reset after} reply, durations are in seconds
+func parseResult(reply []interface{}) (ratelimit.Result, error) {
+	if len(reply) != 4 {
+		return ratelimit.Result{}, errors.Errorf("unexpected rate limit reply length %d", len(reply))
+	}
+
+	allowed, err := redigo.Bool(reply[0], nil)
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	remaining, err := redigo.Int(reply[1], nil)
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	retryAfter, err := parseSeconds(reply[2])
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	resetAfter, err := parseSeconds(reply[3])
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	return ratelimit.Result{
+		Allowed:    allowed,
+		Remaining:  remaining,
+		RetryAfter: retryAfter,
+		ResetAfter: resetAfter,
+	}, nil
+}
+
+func parseSeconds(reply interface{}) (time.Duration, error) {
+	s, err := redigo.String(reply, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func do(ctx context.Context, connectionPool *redigo.Pool, fn func(conn redigo.Conn) error) error {
+	conn, err := connectionPool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return fn(conn)
+}
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	cacheredis "github.com/andredubov/golibs/pkg/client/cache/redis"
+	"github.com/andredubov/golibs/pkg/ratelimit"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// slidingWindowScript keeps a log of request timestamps of the key in a sorted set
+var slidingWindowScript = cacheredis.NewScript(1, `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local member = ARGV[4]
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+
+local reset_after = 0
+local newest = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+if newest[2] then
+	reset_after = tonumber(newest[2]) + window - now
+end
+
+if count + cost > limit then
+	local retry_after = window
+	if cost <= limit then
+		local oldest = redis.call("ZRANGE", KEYS[1], count + cost - limit - 1, count + cost - limit - 1, "WITHSCORES")
+		retry_after = tonumber(oldest[2]) + window - now
+	end
+	return {0, limit - count, tostring(retry_after / 1000), tostring(reset_after / 1000)}
+end
+
+for i = 1, cost do
+	redis.call("ZADD", KEYS[1], now, member .. ":" .. i)
+end
+redis.call("PEXPIRE", KEYS[1], window)
+
+return {1, limit - count - cost, "0", tostring(window / 1000)}
+`)
+
+type slidingWindow struct {
+	connectionPool *redigo.Pool
+	prefix         string
+}
+
+// NewSlidingWindow returns a limiter implementing sliding window log algorithm, keys are prefixed with prefix
+func NewSlidingWindow(connectionPool *redigo.Pool, prefix string) ratelimit.Limiter {
+	return &slidingWindow{
+		connectionPool: connectionPool,
+		prefix:         prefix,
+	}
+}
+
+// Allow reports whether a request may happen now
+func (s *slidingWindow) Allow(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return s.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN reports whether n requests may happen now
+func (s *slidingWindow) AllowN(ctx context.Context, key string, limit ratelimit.Limit, n int) (ratelimit.Result, error) {
+	if limit.IsZero() {
+		return ratelimit.Result{}, errors.New("rate limit is not set")
+	}
+
+	member, err := newMember()
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	var reply []interface{}
+	err = do(ctx, s.connectionPool, func(conn redigo.Conn) error {
+		var errEx error
+		reply, errEx = slidingWindowScript.Do(ctx, conn, s.prefix+key,
+			limit.Rate, limit.Period.Milliseconds(), n, member,
+		).Values()
+
+		return errEx
+	})
+	if err != nil {
+		return ratelimit.Result{}, errors.Wrapf(err, "failed to limit key %q", key)
+	}
+
+	return parseResult(reply)
+}
+
+// Reset clears the log of the key
+func (s *slidingWindow) Reset(ctx context.Context, key string) error {
+	return do(ctx, s.connectionPool, func(conn redigo.Conn) error {
+		_, err := redigo.DoContext(conn, ctx, "DEL", s.prefix+key)
+		return err
+	})
+}
+
+func newMember() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "failed to generate request id")
+	}
+
+	return hex.EncodeToString(buf), nil
+}